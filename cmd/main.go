@@ -27,22 +27,37 @@ func main() {
     if err != nil {
         log.Fatalf("Failed to connect to MongoDB: %v", err)
     }
-    defer database.Close()
 
-    if err := os.MkdirAll(cfg.Watcher.InputDir, 0755); err != nil {
-        log.Fatalf("Failed to create input directory: %v", err)
+    sources, err := cfg.Watcher.GetSources()
+    if err != nil {
+        log.Fatalf("Invalid watcher sources: %v", err)
     }
-    if err := os.MkdirAll(cfg.Watcher.OutputDir, 0755); err != nil {
-        log.Fatalf("Failed to create output directory: %v", err)
+    for _, source := range sources {
+        if err := os.MkdirAll(source.InputDir, 0755); err != nil {
+            log.Fatalf("Failed to create input directory: %v", err)
+        }
+        if err := os.MkdirAll(source.OutputDir, 0755); err != nil {
+            log.Fatalf("Failed to create output directory: %v", err)
+        }
     }
 
     ctx, cancel := context.WithCancel(context.Background())
     defer cancel()
 
-    workerPool := processor.NewWorkerPool(database, &cfg.Watcher)
+    workerPool, err := processor.NewWorkerPool(database, &cfg.Watcher)
+    if err != nil {
+        log.Fatalf("Failed to create worker pool: %v", err)
+    }
+
+    if len(os.Args) > 1 {
+        code := runCommand(workerPool, os.Args[1:])
+        database.Close()
+        os.Exit(code)
+    }
+
     workerPool.Start(ctx)
 
-    handler := api.NewHandler(database)
+    handler := api.NewHandler(database, workerPool)
     router := mux.NewRouter()
     handler.RegisterRoutes(router)
 
@@ -69,17 +84,68 @@ func main() {
     <-quit
 
     log.Println("Shutting down server...")
+
+    // The pool stops scanning and leasing new jobs right away, while the
+    // API server finishes the requests in flight.
+    ctxDrain, cancelDrain := context.WithTimeout(context.Background(), cfg.Watcher.GetShutdownTimeout())
+    defer cancelDrain()
+
+    drained := make(chan error, 1)
+    go func() {
+        drained <- workerPool.Shutdown(ctxDrain)
+    }()
     
     ctxShutdown, cancelShutdown := context.WithTimeout(context.Background(), 30*time.Second)
     defer cancelShutdown()
     
     if err := srv.Shutdown(ctxShutdown); err != nil {
-        log.Fatalf("Server forced to shutdown: %v", err)
+        log.Printf("Server forced to shutdown: %v", err)
     }
-    
+
+    log.Println("Waiting for running jobs to finish...")
+
+    if err := <-drained; err != nil {
+        log.Printf("Some jobs were interrupted: %v", err)
+    }
+
+    if err := database.Close(); err != nil {
+        log.Printf("Error closing MongoDB connection: %v", err)
+    }
+
     log.Println("Server stopped")
 }
 
+// runCommand runs a one-off command instead of the service:
+//
+//	reprocess <file name or glob>
+func runCommand(workerPool *processor.WorkerPool, args []string) int {
+    if args[0] != "reprocess" || len(args) != 2 {
+        fmt.Fprintln(os.Stderr, "usage: main reprocess <file name or glob>")
+        return 2
+    }
+
+    results, err := workerPool.Reprocess(context.Background(), args[1])
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Reprocess failed: %v\n", err)
+        return 1
+    }
+    if len(results) == 0 {
+        fmt.Fprintf(os.Stderr, "No processed files match %s\n", args[1])
+        return 1
+    }
+
+    code := 0
+    for _, result := range results {
+        if result.Error != "" {
+            fmt.Printf("%s: %s\n", result.FileName, result.Error)
+            code = 1
+            continue
+        }
+        fmt.Printf("%s: moved back from %s, %d rows removed\n", result.FileName, result.From, result.DeletedRows)
+    }
+    return code
+}
+
 func loggingMiddleware(next http.Handler) http.Handler {
     return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
         log.Printf("%s %s", r.Method, r.RequestURI)
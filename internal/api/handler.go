@@ -2,23 +2,38 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"path"
 	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
 	"github.com/tsv-processor/internal/db"
+	"github.com/tsv-processor/internal/processor"
 )
 
 type Handler struct {
-	db *db.MongoDB
+	db   *db.MongoDB
+	pool *processor.WorkerPool
 }
 
-func NewHandler(db *db.MongoDB) *Handler {
-	return &Handler{db: db}
+func NewHandler(db *db.MongoDB, pool *processor.WorkerPool) *Handler {
+	return &Handler{db: db, pool: pool}
 }
 
 func (h *Handler) RegisterRoutes(r *mux.Router) {
 	r.HandleFunc("/api/devices/{unit_guid}", h.getDeviceDataByGUID).Methods("GET")
+	r.HandleFunc("/api/devices/{unit_guid}/report", h.requestReport).Methods("POST")
+	r.HandleFunc("/api/jobs", h.getJobs).Methods("GET")
+	r.HandleFunc("/api/jobs/{id}", h.getJob).Methods("GET")
+	r.HandleFunc("/api/jobs/{id}/requeue", h.requeueJob).Methods("POST")
+	r.HandleFunc("/api/jobs/{id}/cancel", h.cancelJob).Methods("POST")
+	r.HandleFunc("/api/reprocess", h.reprocess).Methods("POST")
 }
 
 func (h *Handler) getDeviceDataByGUID(w http.ResponseWriter, r *http.Request) {
@@ -38,6 +53,174 @@ func (h *Handler) getDeviceDataByGUID(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(data)
 }
 
+// requestReport queues a report of a device, optionally of a named source and
+// with a named template. The report is generated by the report workers.
+func (h *Handler) requestReport(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	unitGUID := mux.Vars(r)["unit_guid"]
+	source := r.URL.Query().Get("source")
+	template := r.URL.Query().Get("template")
+
+	err := h.pool.RequestReport(r.Context(), source, unitGUID, template)
+	if errors.Is(err, processor.ErrUnknownSource) || errors.Is(err, processor.ErrUnknownTemplate) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"source":    source,
+		"unit_guid": unitGUID,
+		"template":  template,
+	})
+}
+
+func (h *Handler) getJobs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	page, limit := getPaginationParams(r)
+
+	filter, err := getJobFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	jobs, err := h.db.GetJobs(r.Context(), filter, page, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(jobs)
+}
+
+func (h *Handler) getJob(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid job id", http.StatusBadRequest)
+		return
+	}
+
+	job, err := h.db.GetJob(r.Context(), id)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(job)
+}
+
+func (h *Handler) requeueJob(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid job id", http.StatusBadRequest)
+		return
+	}
+
+	job, err := h.db.RequeueJob(r.Context(), id)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "dead-letter job not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(job)
+}
+
+// cancelJob asks the worker running a job to stop it. The worker notices the
+// request within a second; the job then ends as failed.
+func (h *Handler) cancelJob(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid job id", http.StatusBadRequest)
+		return
+	}
+
+	job, err := h.db.CancelJob(r.Context(), id)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "running job not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// reprocess moves the processed files matching the file parameter, a name or
+// a glob, back into the pipeline.
+func (h *Handler) reprocess(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	pattern := r.URL.Query().Get("file")
+	if pattern == "" {
+		http.Error(w, "file is required", http.StatusBadRequest)
+		return
+	}
+
+	results, err := h.pool.Reprocess(r.Context(), pattern)
+	if errors.Is(err, path.ErrBadPattern) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(results) == 0 {
+		http.Error(w, "no processed files match", http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(results)
+}
+
+func getJobFilter(r *http.Request) (db.JobFilter, error) {
+	query := r.URL.Query()
+	filter := db.JobFilter{
+		Source:   query.Get("source"),
+		Status:   query.Get("status"),
+		FileName: query.Get("file_name"),
+		WorkerID: query.Get("worker_id"),
+	}
+
+	var err error
+	if from := query.Get("from"); from != "" {
+		if filter.From, err = time.Parse(time.RFC3339, from); err != nil {
+			return filter, fmt.Errorf("invalid from: %w", err)
+		}
+	}
+	if to := query.Get("to"); to != "" {
+		if filter.To, err = time.Parse(time.RFC3339, to); err != nil {
+			return filter, fmt.Errorf("invalid to: %w", err)
+		}
+	}
+
+	return filter, nil
+}
+
 func getPaginationParams(r *http.Request) (page, limit int64) {
 	pageStr := r.URL.Query().Get("page")
 	limitStr := r.URL.Query().Get("limit")
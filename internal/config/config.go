@@ -3,6 +3,8 @@ package config
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v3"
@@ -23,12 +25,137 @@ type DatabaseConfig struct {
 }
 
 type WatcherConfig struct {
-	InputDir     string        `yaml:"input_dir"`
-	OutputDir    string        `yaml:"output_dir"`
-	PollInterval time.Duration `yaml:"poll_interval"`
-	Workers      int           `yaml:"workers"`
+	InputDir     string           `yaml:"input_dir"`
+	OutputDir    string           `yaml:"output_dir"`
+	PollInterval time.Duration    `yaml:"poll_interval"`
+	Workers      int              `yaml:"workers"`
+	MaxErrorRate float64          `yaml:"max_error_rate"`
+	BatchSize    int              `yaml:"batch_size"`
+	Formats      []string         `yaml:"formats"`
+	Encoding     string           `yaml:"encoding"`
+	Validation   ValidationConfig `yaml:"validation"`
+	// DuplicateNamePolicy decides what happens when a file arrives under an
+	// already processed name with different content.
+	DuplicateNamePolicy string          `yaml:"duplicate_name_policy"`
+	Readiness           ReadinessConfig `yaml:"readiness"`
+	// JobVisibilityTimeout is how long a job stays leased to a worker that
+	// stopped reporting progress before another worker may take it over.
+	JobVisibilityTimeout time.Duration `yaml:"job_visibility_timeout"`
+	Retry                RetryConfig   `yaml:"retry"`
+	// ShutdownTimeout is how long running jobs may take to finish on
+	// shutdown before they are interrupted.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// LeaderElection lets only one of several instances sharing the input
+	// directories scan them; LeaderLease is how long a leader that stopped
+	// renewing keeps the role.
+	LeaderElection bool          `yaml:"leader_election"`
+	LeaderLease    time.Duration `yaml:"leader_lease"`
+	Reports        ReportsConfig `yaml:"reports"`
+	// Recursive and PathTemplate are the defaults for every source.
+	Recursive    bool   `yaml:"recursive"`
+	PathTemplate string `yaml:"path_template"`
+	// Sources lists the watched directories. Without it input_dir and
+	// output_dir make up a single unnamed source.
+	Sources []SourceConfig `yaml:"sources"`
 }
 
+// SourceConfig is one watched input directory. Settings left empty fall back
+// to the watcher-wide ones.
+type SourceConfig struct {
+	Name      string `yaml:"name"`
+	InputDir  string `yaml:"input_dir"`
+	OutputDir string `yaml:"output_dir"`
+	// Pattern is a glob that the names of input files must match.
+	Pattern string `yaml:"pattern"`
+	// Format forces the parser for every file of the source instead of
+	// detecting it from the extension or the content.
+	Format string `yaml:"format"`
+	// Formats are the input formats enabled for the source.
+	Formats  []string `yaml:"formats"`
+	Encoding string   `yaml:"encoding"`
+	Workers  int      `yaml:"workers"`
+	// Site is stamped onto every record ingested from the source.
+	Site string `yaml:"site"`
+	// Recursive makes subdirectories of input_dir be scanned as well.
+	Recursive bool `yaml:"recursive"`
+	// PathTemplate names the directory levels below input_dir, such as
+	// "{site}/{line}"; the named segments are stored as metadata of the file
+	// and its records. Setting it implies recursive scanning.
+	PathTemplate string `yaml:"path_template"`
+	// ReportTemplate is the name of the report template of the source.
+	ReportTemplate string `yaml:"report_template"`
+}
+
+// RetryConfig controls how jobs that failed for a transient reason, such as a
+// lost database connection, are retried before they are dead-lettered.
+type RetryConfig struct {
+	MaxAttempts    int           `yaml:"max_attempts"`
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+}
+
+// ReportsConfig controls the queue that generates device reports apart from
+// ingestion.
+type ReportsConfig struct {
+	Workers int `yaml:"workers"`
+	// Window is how long a report is held back after it is requested, so
+	// that the files arriving for a device meanwhile produce one report.
+	Window time.Duration `yaml:"window"`
+	// Scope decides which records of a device a report covers: those of
+	// the file just processed, the latest version of each msg_id, or the
+	// full history.
+	Scope string `yaml:"scope"`
+	// MaxRows limits the rows of the detail table of a report; zero means
+	// no limit.
+	MaxRows int `yaml:"max_rows"`
+	// Templates names the report template files, YAML or JSON; Template is
+	// the one used unless a source or a request picks another. The built-in
+	// layout is available as "default".
+	Templates map[string]string `yaml:"templates"`
+	Template  string            `yaml:"template"`
+}
+
+// ReadinessConfig describes how to tell that a file in the input directory
+// has been completely written.
+type ReadinessConfig struct {
+	Strategy       string        `yaml:"strategy"`
+	StableFor      time.Duration `yaml:"stable_for"`
+	MarkerSuffixes []string      `yaml:"marker_suffixes"`
+	TempSuffix     string        `yaml:"temp_suffix"`
+}
+
+type ValidationConfig struct {
+	Columns map[string]ColumnRule `yaml:"columns"`
+}
+
+type ColumnRule struct {
+	Required bool     `yaml:"required"`
+	Enum     []string `yaml:"enum"`
+	Min      *int     `yaml:"min"`
+	Max      *int     `yaml:"max"`
+	Pattern  string   `yaml:"pattern"`
+	Format   string   `yaml:"format"`
+}
+
+const (
+	DuplicateNameReject  = "reject"
+	DuplicateNameReplace = "replace"
+	DuplicateNameKeep    = "keep"
+)
+
+const (
+	ReadinessNone   = "none"
+	ReadinessStable = "stable"
+	ReadinessMarker = "marker"
+	ReadinessRename = "rename"
+)
+
+const (
+	ReportScopeFile    = "file"
+	ReportScopeLatest  = "latest"
+	ReportScopeHistory = "history"
+)
+
 type APIConfig struct {
 	Host string `yaml:"host"`
 	Port int    `yaml:"port"`
@@ -54,3 +181,183 @@ func (c *DatabaseConfig) GetMongoURI() string {
 	}
 	return "mongodb://localhost:27017"
 }
+
+func (c *WatcherConfig) GetBatchSize() int {
+	if c.BatchSize > 0 {
+		return c.BatchSize
+	}
+	return 1000
+}
+
+// GetMaxErrorRate returns the share of invalid rows, from 0 to 1, above
+// which a file is rejected.
+func (c *WatcherConfig) GetMaxErrorRate() (float64, error) {
+	if c.MaxErrorRate < 0 || c.MaxErrorRate > 1 {
+		return 0, fmt.Errorf("max_error_rate must be between 0 and 1, got %v", c.MaxErrorRate)
+	}
+	return c.MaxErrorRate, nil
+}
+
+func (c *WatcherConfig) GetDuplicateNamePolicy() (string, error) {
+	switch c.DuplicateNamePolicy {
+	case "":
+		return DuplicateNameReject, nil
+	case DuplicateNameReject, DuplicateNameReplace, DuplicateNameKeep:
+		return c.DuplicateNamePolicy, nil
+	}
+	return "", fmt.Errorf("unknown duplicate_name_policy %q", c.DuplicateNamePolicy)
+}
+
+// GetSources returns the watched sources with the watcher-wide defaults
+// applied. The output of a named source defaults to a subdirectory of
+// output_dir, so that files of different sources do not mix.
+func (c *WatcherConfig) GetSources() ([]SourceConfig, error) {
+	if len(c.Sources) == 0 {
+		return []SourceConfig{{
+			InputDir:       c.InputDir,
+			OutputDir:      c.OutputDir,
+			Pattern:        "*",
+			Formats:        c.Formats,
+			Encoding:       c.Encoding,
+			Workers:        c.Workers,
+			Recursive:      c.Recursive,
+			PathTemplate:   c.PathTemplate,
+			ReportTemplate: c.Reports.Template,
+		}}, nil
+	}
+
+	sources := make([]SourceConfig, len(c.Sources))
+	names := make(map[string]bool, len(c.Sources))
+	for i, s := range c.Sources {
+		if s.Name == "" || strings.ContainsAny(s.Name, `/\`) {
+			return nil, fmt.Errorf("source %d: invalid name %q", i+1, s.Name)
+		}
+		if names[s.Name] {
+			return nil, fmt.Errorf("duplicate source name %q", s.Name)
+		}
+		names[s.Name] = true
+
+		if s.InputDir == "" {
+			return nil, fmt.Errorf("source %s: input_dir is required", s.Name)
+		}
+		if s.OutputDir == "" {
+			s.OutputDir = filepath.Join(c.OutputDir, s.Name)
+		}
+		if s.Pattern == "" {
+			s.Pattern = "*"
+		}
+		if len(s.Formats) == 0 {
+			s.Formats = c.Formats
+		}
+		if s.Encoding == "" {
+			s.Encoding = c.Encoding
+		}
+		if s.Workers <= 0 {
+			s.Workers = c.Workers
+		}
+		if s.PathTemplate == "" {
+			s.PathTemplate = c.PathTemplate
+		}
+		if s.ReportTemplate == "" {
+			s.ReportTemplate = c.Reports.Template
+		}
+		s.Recursive = s.Recursive || c.Recursive
+		sources[i] = s
+	}
+
+	return sources, nil
+}
+
+func (c *ReadinessConfig) GetStrategy() string {
+	if c.Strategy != "" {
+		return c.Strategy
+	}
+	return ReadinessStable
+}
+
+func (c *ReadinessConfig) GetStableFor() time.Duration {
+	if c.StableFor > 0 {
+		return c.StableFor
+	}
+	return 5 * time.Second
+}
+
+func (c *ReadinessConfig) GetMarkerSuffixes() []string {
+	if len(c.MarkerSuffixes) > 0 {
+		return c.MarkerSuffixes
+	}
+	return []string{".done", ".ready"}
+}
+
+func (c *ReadinessConfig) GetTempSuffix() string {
+	if c.TempSuffix != "" {
+		return c.TempSuffix
+	}
+	return ".tmp"
+}
+
+func (c *WatcherConfig) GetJobVisibilityTimeout() time.Duration {
+	if c.JobVisibilityTimeout > 0 {
+		return c.JobVisibilityTimeout
+	}
+	return 5 * time.Minute
+}
+
+func (c *WatcherConfig) GetShutdownTimeout() time.Duration {
+	if c.ShutdownTimeout > 0 {
+		return c.ShutdownTimeout
+	}
+	return 30 * time.Second
+}
+
+func (c *WatcherConfig) GetLeaderLease() time.Duration {
+	if c.LeaderLease > 0 {
+		return c.LeaderLease
+	}
+	return 15 * time.Second
+}
+
+func (c *ReportsConfig) GetWorkers() int {
+	if c.Workers > 0 {
+		return c.Workers
+	}
+	return 1
+}
+
+func (c *ReportsConfig) GetWindow() time.Duration {
+	if c.Window > 0 {
+		return c.Window
+	}
+	return 30 * time.Second
+}
+
+func (c *ReportsConfig) GetScope() (string, error) {
+	switch c.Scope {
+	case "":
+		return ReportScopeHistory, nil
+	case ReportScopeFile, ReportScopeLatest, ReportScopeHistory:
+		return c.Scope, nil
+	}
+	return "", fmt.Errorf("unknown report scope %q", c.Scope)
+}
+
+func (c *RetryConfig) GetMaxAttempts() int {
+	if c.MaxAttempts > 0 {
+		return c.MaxAttempts
+	}
+	return 5
+}
+
+func (c *RetryConfig) GetInitialBackoff() time.Duration {
+	if c.InitialBackoff > 0 {
+		return c.InitialBackoff
+	}
+	return 30 * time.Second
+}
+
+func (c *RetryConfig) GetMaxBackoff() time.Duration {
+	if c.MaxBackoff > 0 {
+		return c.MaxBackoff
+	}
+	return 30 * time.Minute
+}
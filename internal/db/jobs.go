@@ -0,0 +1,337 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/tsv-processor/internal/models"
+)
+
+// EnqueueJob adds a job for the file unless one for the same name and content
+// is already queued, running, interrupted or dead-lettered. It reports whether a new job
+// was created.
+func (db *MongoDB) EnqueueJob(ctx context.Context, job *models.Job) (bool, error) {
+	collection := db.database.Collection(Collections.Jobs)
+
+	now := time.Now()
+	if job.ID.IsZero() {
+		job.ID = primitive.NewObjectID()
+	}
+	job.Status = models.JobQueued
+	job.NextAttemptAt = now
+	job.CreatedAt = now
+	job.UpdatedAt = now
+
+	filter := bson.M{
+		"source":       sourceValue(job.Source),
+		"file_name":    job.FileName,
+		"content_hash": job.ContentHash,
+		"status":       bson.M{"$in": []string{models.JobQueued, models.JobRunning, models.JobInterrupted, models.JobDeadLetter}},
+	}
+	update := bson.M{"$setOnInsert": job}
+
+	res, err := collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if err != nil {
+		return false, err
+	}
+
+	return res.UpsertedCount > 0, nil
+}
+
+// LeaseJob hands the oldest due job of a source to owner for the visibility
+// timeout. Interrupted jobs and jobs whose lease has expired are available
+// again. It returns nil when there is nothing to do.
+func (db *MongoDB) LeaseJob(ctx context.Context, owner, source string, visibility time.Duration) (*models.Job, error) {
+	collection := db.database.Collection(Collections.Jobs)
+
+	now := time.Now()
+	filter := bson.M{
+		"source": sourceValue(source),
+		"$or": bson.A{
+			bson.M{"status": models.JobQueued, "next_attempt_at": bson.M{"$not": bson.M{"$gt": now}}},
+			bson.M{"status": models.JobRunning, "lease_until": bson.M{"$lt": now}},
+			bson.M{"status": models.JobInterrupted},
+		},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"status":      models.JobRunning,
+			"lease_owner": owner,
+			"lease_until": now.Add(visibility),
+			"worker_id":   owner,
+			"started_at":  now,
+			"updated_at":  now,
+		},
+		"$unset": bson.M{"finished_at": ""},
+		"$inc":   bson.M{"attempts": 1},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "next_attempt_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var job models.Job
+	err := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&job)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+// ExtendLease keeps a long running job leased to its owner and records its
+// progress so far. It fails with mongo.ErrNoDocuments if the lease has been
+// lost.
+func (db *MongoDB) ExtendLease(ctx context.Context, id primitive.ObjectID, owner string, visibility time.Duration, stats models.JobStats) error {
+	collection := db.database.Collection(Collections.Jobs)
+
+	now := time.Now()
+	filter := bson.M{"_id": id, "status": models.JobRunning, "lease_owner": owner}
+	set := bson.M{"lease_until": now.Add(visibility), "updated_at": now}
+	setStats(set, stats)
+	update := bson.M{"$set": set}
+
+	res, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// settleJob ends the current attempt of a job leased to owner, setting the
+// given fields and recording the statistics of the attempt.
+func (db *MongoDB) settleJob(ctx context.Context, id primitive.ObjectID, owner string, set bson.M, stats models.JobStats) error {
+	collection := db.database.Collection(Collections.Jobs)
+
+	now := time.Now()
+	setStats(set, stats)
+	set["finished_at"] = now
+	set["updated_at"] = now
+
+	filter := bson.M{"_id": id, "lease_owner": owner}
+	update := bson.M{
+		"$set":   set,
+		"$unset": bson.M{"lease_owner": "", "lease_until": ""},
+	}
+	if set["status"] == models.JobInterrupted {
+		// An interrupted attempt does not count against the retry limit.
+		update["$inc"] = bson.M{"attempts": -1}
+	}
+
+	_, err := collection.UpdateOne(ctx, filter, update)
+	return err
+}
+
+// setStats adds the statistics of an attempt to an update. Reports are left
+// alone, since the report queue may have added some.
+func setStats(set bson.M, stats models.JobStats) {
+	set["stats.rows_read"] = stats.RowsRead
+	set["stats.rows_written"] = stats.RowsWritten
+	set["stats.invalid_rows"] = stats.InvalidRows
+	set["stats.stages"] = stats.Stages
+}
+
+// AckJob marks a leased job as succeeded.
+func (db *MongoDB) AckJob(ctx context.Context, id primitive.ObjectID, owner string, stats models.JobStats) error {
+	return db.settleJob(ctx, id, owner, bson.M{"status": models.JobSucceeded}, stats)
+}
+
+// FailJob marks a leased job as failed for good, such as for a rejected file.
+func (db *MongoDB) FailJob(ctx context.Context, id primitive.ObjectID, owner, lastErr string, stats models.JobStats) error {
+	return db.settleJob(ctx, id, owner, bson.M{"status": models.JobFailed, "last_error": lastErr}, stats)
+}
+
+// RetryJob returns a failed job to the queue, to be leased again no earlier
+// than at.
+func (db *MongoDB) RetryJob(ctx context.Context, id primitive.ObjectID, owner string, at time.Time, lastErr string, stats models.JobStats) error {
+	set := bson.M{
+		"status":          models.JobQueued,
+		"next_attempt_at": at,
+		"last_error":      lastErr,
+	}
+	return db.settleJob(ctx, id, owner, set, stats)
+}
+
+// InterruptJob releases a job that was cancelled by a shutdown, to be taken
+// again on the next start.
+func (db *MongoDB) InterruptJob(ctx context.Context, id primitive.ObjectID, owner string, stats models.JobStats) error {
+	return db.settleJob(ctx, id, owner, bson.M{"status": models.JobInterrupted}, stats)
+}
+
+// PostponeJob returns a leased job to the queue until at without counting the
+// attempt, such as when its file is claimed by another instance.
+func (db *MongoDB) PostponeJob(ctx context.Context, id primitive.ObjectID, owner string, at time.Time) error {
+	collection := db.database.Collection(Collections.Jobs)
+
+	filter := bson.M{"_id": id, "lease_owner": owner}
+	update := bson.M{
+		"$set":   bson.M{"status": models.JobQueued, "next_attempt_at": at, "updated_at": time.Now()},
+		"$unset": bson.M{"lease_owner": "", "lease_until": ""},
+		"$inc":   bson.M{"attempts": -1},
+	}
+
+	_, err := collection.UpdateOne(ctx, filter, update)
+	return err
+}
+
+func (db *MongoDB) DeadLetterJob(ctx context.Context, id primitive.ObjectID, owner, lastErr string, stats models.JobStats) error {
+	return db.settleJob(ctx, id, owner, bson.M{"status": models.JobDeadLetter, "last_error": lastErr}, stats)
+}
+
+// CancelJob asks the worker running a job to stop it. It returns
+// mongo.ErrNoDocuments if there is no such running job.
+func (db *MongoDB) CancelJob(ctx context.Context, id primitive.ObjectID) (*models.Job, error) {
+	collection := db.database.Collection(Collections.Jobs)
+
+	filter := bson.M{"_id": id, "status": models.JobRunning}
+	update := bson.M{"$set": bson.M{"cancel_requested": true, "updated_at": time.Now()}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var job models.Job
+	if err := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&job); err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+// IsJobCancelRequested reports whether a cancel has been requested for a job
+// leased to owner.
+func (db *MongoDB) IsJobCancelRequested(ctx context.Context, id primitive.ObjectID, owner string) (bool, error) {
+	collection := db.database.Collection(Collections.Jobs)
+
+	filter := bson.M{"_id": id, "lease_owner": owner, "cancel_requested": true}
+	count, err := collection.CountDocuments(ctx, filter, options.Count().SetLimit(1))
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// RequeueJob puts a dead-lettered job back into the queue with a fresh
+// attempt count. It returns mongo.ErrNoDocuments if there is no such job in
+// the dead-letter state.
+func (db *MongoDB) RequeueJob(ctx context.Context, id primitive.ObjectID) (*models.Job, error) {
+	collection := db.database.Collection(Collections.Jobs)
+
+	now := time.Now()
+	filter := bson.M{"_id": id, "status": models.JobDeadLetter}
+	update := bson.M{
+		"$set": bson.M{
+			"status":          models.JobQueued,
+			"attempts":        0,
+			"next_attempt_at": now,
+			"updated_at":      now,
+		},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var job models.Job
+	if err := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&job); err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+// JobFilter selects jobs for GetJobs. Empty fields match any job; From and
+// To bound the creation time.
+type JobFilter struct {
+	Source   string
+	Status   string
+	FileName string
+	WorkerID string
+	From     time.Time
+	To       time.Time
+}
+
+func (f JobFilter) query() bson.M {
+	query := bson.M{}
+	if f.Source != "" {
+		query["source"] = f.Source
+	}
+	if f.Status != "" {
+		query["status"] = f.Status
+	}
+	if f.FileName != "" {
+		query["file_name"] = f.FileName
+	}
+	if f.WorkerID != "" {
+		query["worker_id"] = f.WorkerID
+	}
+
+	created := bson.M{}
+	if !f.From.IsZero() {
+		created["$gte"] = f.From
+	}
+	if !f.To.IsZero() {
+		created["$lt"] = f.To
+	}
+	if len(created) > 0 {
+		query["created_at"] = created
+	}
+
+	return query
+}
+
+func (db *MongoDB) GetJob(ctx context.Context, id primitive.ObjectID) (*models.Job, error) {
+	collection := db.database.Collection(Collections.Jobs)
+
+	var job models.Job
+	if err := collection.FindOne(ctx, bson.M{"_id": id}).Decode(&job); err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+func (db *MongoDB) GetJobs(ctx context.Context, jobFilter JobFilter, page, limit int64) (*models.PaginatedJobsResponse, error) {
+	collection := db.database.Collection(Collections.Jobs)
+
+	filter := jobFilter.query()
+
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetSkip((page - 1) * limit).
+		SetLimit(limit)
+
+	cursor, err := collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	jobs := []models.Job{}
+	if err := cursor.All(ctx, &jobs); err != nil {
+		return nil, err
+	}
+
+	totalPages := total / limit
+	if total%limit > 0 {
+		totalPages++
+	}
+
+	return &models.PaginatedJobsResponse{
+		Data:       jobs,
+		Total:      total,
+		Page:       page,
+		Limit:      limit,
+		TotalPages: totalPages,
+	}, nil
+}
@@ -0,0 +1,48 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AcquireLease takes the named lease for owner, or renews it if owner already
+// holds it, until ttl from now. It reports false while another owner holds
+// a lease that has not expired. Instances are expected to have roughly
+// synchronized clocks.
+func (db *MongoDB) AcquireLease(ctx context.Context, name, owner string, ttl time.Duration) (bool, error) {
+	collection := db.database.Collection(Collections.Leases)
+
+	now := time.Now()
+	filter := bson.M{
+		"_id": name,
+		"$or": bson.A{
+			bson.M{"owner": owner},
+			bson.M{"lease_until": bson.M{"$lt": now}},
+		},
+	}
+	update := bson.M{"$set": bson.M{"owner": owner, "lease_until": now.Add(ttl)}}
+
+	// A lease held by someone else does not match the filter, so the upsert
+	// tries to insert a second document with the same _id and fails.
+	_, err := collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// ReleaseLease gives up a lease held by owner.
+func (db *MongoDB) ReleaseLease(ctx context.Context, name, owner string) error {
+	collection := db.database.Collection(Collections.Leases)
+
+	_, err := collection.DeleteOne(ctx, bson.M{"_id": name, "owner": owner})
+	return err
+}
@@ -3,6 +3,8 @@ package db
 import (
 	"context"
 	"fmt"
+	"log"
+	"regexp"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -16,20 +18,27 @@ import (
 )
 
 type MongoDB struct {
-	client   *mongo.Client
-	database *mongo.Database
+	client       *mongo.Client
+	database     *mongo.Database
+	transactions bool
 }
 
 type CollectionNames struct {
 	DeviceData     string
 	ProcessedFiles string
 	ProcessingErrs string
+	Jobs           string
+	Leases         string
+	ReportRequests string
 }
 
 var Collections = CollectionNames{
 	DeviceData:     "device_data",
 	ProcessedFiles: "processed_files",
 	ProcessingErrs: "processing_errors",
+	Jobs:           "jobs",
+	Leases:         "leases",
+	ReportRequests: "report_requests",
 }
 
 func NewMongoDB(cfg *config.DatabaseConfig) (*MongoDB, error) {
@@ -65,12 +74,58 @@ func NewMongoDB(cfg *config.DatabaseConfig) (*MongoDB, error) {
 		return nil, fmt.Errorf("failed to create indexes: %w", err)
 	}
 
+	transactions := supportsTransactions(ctx, client)
+	if !transactions {
+		log.Printf("MongoDB does not support transactions (standalone server), falling back to cleanup by file name")
+	}
+
 	return &MongoDB{
-		client:   client,
-		database: db,
+		client:       client,
+		database:     db,
+		transactions: transactions,
 	}, nil
 }
 
+// supportsTransactions reports whether the server is a replica set member or
+// a mongos router; standalone servers cannot run multi-document transactions.
+func supportsTransactions(ctx context.Context, client *mongo.Client) bool {
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+
+	err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+	if err != nil {
+		return false
+	}
+
+	return hello.SetName != "" || hello.Msg == "isdbgrid"
+}
+
+func (db *MongoDB) SupportsTransactions() bool {
+	return db.transactions
+}
+
+// RunInTransaction runs fn so that all of its writes are committed together,
+// retrying it on transient transaction errors. Without transaction support fn
+// is simply called, and the caller is responsible for cleaning up.
+func (db *MongoDB) RunInTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if !db.transactions {
+		return fn(ctx)
+	}
+
+	session, err := db.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
+
 func createIndexes(ctx context.Context, db *mongo.Database) error {
 	deviceDataColl := db.Collection(Collections.DeviceData)
 	deviceDataIndexes := []mongo.IndexModel{
@@ -93,16 +148,41 @@ func createIndexes(ctx context.Context, db *mongo.Database) error {
 			},
 			Options: options.Index().SetBackground(true),
 		},
+		{
+			Keys: bson.D{
+				{Key: "unit_guid", Value: 1},
+				{Key: "source", Value: 1},
+				{Key: "created_at", Value: -1},
+			},
+			Options: options.Index().SetBackground(true),
+		},
 	}
 	if _, err := deviceDataColl.Indexes().CreateMany(ctx, deviceDataIndexes); err != nil {
 		return err
 	}
 
 	processedFilesColl := db.Collection(Collections.ProcessedFiles)
+	if err := dropUniqueIndex(ctx, processedFilesColl, "file_name_1"); err != nil {
+		return err
+	}
+	// Content is unique per source since sources were introduced.
+	if err := dropUniqueIndex(ctx, processedFilesColl, "content_hash_1"); err != nil {
+		return err
+	}
 	processedFilesIndexes := []mongo.IndexModel{
 		{
 			Keys:    bson.D{{Key: "file_name", Value: 1}},
-			Options: options.Index().SetUnique(true).SetBackground(true),
+			Options: options.Index().SetBackground(true),
+		},
+		{
+			Keys: bson.D{
+				{Key: "source", Value: 1},
+				{Key: "content_hash", Value: 1},
+			},
+			Options: options.Index().
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{"content_hash": bson.M{"$type": "string"}}).
+				SetBackground(true),
 		},
 		{
 			Keys:    bson.D{{Key: "processed_at", Value: -1}},
@@ -124,26 +204,181 @@ func createIndexes(ctx context.Context, db *mongo.Database) error {
 			Options: options.Index().SetBackground(true),
 		},
 	}
-	_, err := processingErrsColl.Indexes().CreateMany(ctx, processingErrsIndexes)
+	if _, err := processingErrsColl.Indexes().CreateMany(ctx, processingErrsIndexes); err != nil {
+		return err
+	}
+
+	jobsColl := db.Collection(Collections.Jobs)
+	jobsIndexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{
+				{Key: "source", Value: 1},
+				{Key: "status", Value: 1},
+				{Key: "next_attempt_at", Value: 1},
+			},
+			Options: options.Index().SetBackground(true),
+		},
+		{
+			Keys: bson.D{
+				{Key: "file_name", Value: 1},
+				{Key: "content_hash", Value: 1},
+			},
+			Options: options.Index().SetBackground(true),
+		},
+		{
+			Keys:    bson.D{{Key: "created_at", Value: -1}},
+			Options: options.Index().SetBackground(true),
+		},
+		{
+			Keys:    bson.D{{Key: "worker_id", Value: 1}},
+			Options: options.Index().SetBackground(true),
+		},
+	}
+	if _, err := jobsColl.Indexes().CreateMany(ctx, jobsIndexes); err != nil {
+		return err
+	}
+
+	reportsColl := db.Collection(Collections.ReportRequests)
+	// Requests are unique per template since report templates were
+	// introduced.
+	if err := dropUniqueIndex(ctx, reportsColl, "source_1_unit_guid_1"); err != nil {
+		return err
+	}
+	reportsIndexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{
+				{Key: "source", Value: 1},
+				{Key: "unit_guid", Value: 1},
+				{Key: "template", Value: 1},
+			},
+			Options: options.Index().SetUnique(true).SetBackground(true),
+		},
+		{
+			Keys:    bson.D{{Key: "due_at", Value: 1}},
+			Options: options.Index().SetBackground(true),
+		},
+	}
+	if _, err := reportsColl.Indexes().CreateMany(ctx, reportsIndexes); err != nil {
+		return err
+	}
+
+	// Expired leases are free to take anyway; the TTL index only keeps
+	// those of crashed instances from piling up.
+	leasesColl := db.Collection(Collections.Leases)
+	_, err := leasesColl.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "lease_until", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0).SetBackground(true),
+	})
 	return err
 }
 
+// dropUniqueIndex removes an index that used to be unique, so that it can be
+// recreated with different options.
+func dropUniqueIndex(ctx context.Context, coll *mongo.Collection, name string) error {
+	cursor, err := coll.Indexes().List(ctx)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var indexes []bson.M
+	if err := cursor.All(ctx, &indexes); err != nil {
+		return err
+	}
+
+	for _, index := range indexes {
+		if index["name"] == name && index["unique"] == true {
+			_, err := coll.Indexes().DropOne(ctx, name)
+			return err
+		}
+	}
+	return nil
+}
+
 func (db *MongoDB) Close() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	return db.client.Disconnect(ctx)
 }
 
-func (db *MongoDB) IsFileProcessed(ctx context.Context, fileName string) (bool, error) {
+// sourceValue selects the records of a source in a filter. The unnamed
+// source of a single directory setup stores no source field, which a nil
+// value matches.
+func sourceValue(source string) interface{} {
+	if source == "" {
+		return nil
+	}
+	return source
+}
+
+// GetProcessedFilesByNames returns the name, content hash and reprocess flag
+// of every record for the given file names of a source in a single query.
+func (db *MongoDB) GetProcessedFilesByNames(ctx context.Context, source string, fileNames []string) ([]models.ProcessedFile, error) {
 	collection := db.database.Collection(Collections.ProcessedFiles)
 
-	filter := bson.M{"file_name": fileName}
-	count, err := collection.CountDocuments(ctx, filter)
+	filter := bson.M{"source": sourceValue(source), "file_name": bson.M{"$in": fileNames}}
+	findOptions := options.Find().SetProjection(bson.M{"file_name": 1, "content_hash": 1, "reprocess_requested": 1})
+	cursor, err := collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var files []models.ProcessedFile
+	if err := cursor.All(ctx, &files); err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+func (db *MongoDB) GetProcessedFileByHash(ctx context.Context, source, contentHash string) (*models.ProcessedFile, error) {
+	collection := db.database.Collection(Collections.ProcessedFiles)
+
+	filter := bson.M{"source": sourceValue(source), "content_hash": contentHash}
+
+	var file models.ProcessedFile
+	err := collection.FindOne(ctx, filter).Decode(&file)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &file, nil
+}
+
+func (db *MongoDB) GetProcessedFilesByName(ctx context.Context, source, fileName string) ([]models.ProcessedFile, error) {
+	collection := db.database.Collection(Collections.ProcessedFiles)
+
+	filter := bson.M{"source": sourceValue(source), "file_name": fileName}
+	findOptions := options.Find().SetSort(bson.D{{Key: "processed_at", Value: -1}})
+	cursor, err := collection.Find(ctx, filter, findOptions)
 	if err != nil {
-		return false, err
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var files []models.ProcessedFile
+	if err := cursor.All(ctx, &files); err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// DeleteOtherFileVersions removes the device data and processed file records
+// of every version of fileName whose content differs from keepHash.
+func (db *MongoDB) DeleteOtherFileVersions(ctx context.Context, source, fileName, keepHash string) error {
+	filter := bson.M{"source": sourceValue(source), "file_name": fileName, "file_hash": bson.M{"$ne": keepHash}}
+	if _, err := db.database.Collection(Collections.DeviceData).DeleteMany(ctx, filter); err != nil {
+		return err
 	}
 
-	return count > 0, nil
+	filter = bson.M{"source": sourceValue(source), "file_name": fileName, "content_hash": bson.M{"$ne": keepHash}}
+	_, err := db.database.Collection(Collections.ProcessedFiles).DeleteMany(ctx, filter)
+	return err
 }
 
 func (db *MongoDB) SaveProcessedFile(ctx context.Context, file *models.ProcessedFile) error {
@@ -153,10 +388,70 @@ func (db *MongoDB) SaveProcessedFile(ctx context.Context, file *models.Processed
 		file.ID = primitive.NewObjectID()
 	}
 
-	_, err := collection.InsertOne(ctx, file)
+	filter := bson.M{"_id": file.ID}
+	_, err := collection.ReplaceOne(ctx, filter, file, options.Replace().SetUpsert(true))
 	return err
 }
 
+// GetTopLevelFilesByPrefix returns the records of files of any source that
+// came from an input directory, not from an archive, whose name starts with
+// prefix.
+func (db *MongoDB) GetTopLevelFilesByPrefix(ctx context.Context, prefix string) ([]models.ProcessedFile, error) {
+	collection := db.database.Collection(Collections.ProcessedFiles)
+
+	filter := bson.M{
+		"file_name":      bson.M{"$regex": "^" + regexp.QuoteMeta(prefix)},
+		"parent_archive": bson.M{"$in": bson.A{nil, ""}},
+	}
+	findOptions := options.Find().SetSort(bson.D{{Key: "processed_at", Value: -1}})
+	cursor, err := collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var files []models.ProcessedFile
+	if err := cursor.All(ctx, &files); err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// MarkForReprocess removes the stored data of a file, and of its members if
+// it is an archive, and flags its records so that the file is processed again
+// once it is back in the input directory. It returns the number of rows
+// removed.
+func (db *MongoDB) MarkForReprocess(ctx context.Context, source, fileName string) (int64, error) {
+	files := db.database.Collection(Collections.ProcessedFiles)
+
+	filter := bson.M{
+		"source": sourceValue(source),
+		"$or": bson.A{
+			bson.M{"file_name": fileName},
+			bson.M{"parent_archive": fileName},
+		},
+	}
+	names, err := files.Distinct(ctx, "file_name", filter)
+	if err != nil {
+		return 0, err
+	}
+
+	// The data goes before the records are flagged: once they are, the
+	// file may already be loading again.
+	dataFilter := bson.M{"source": sourceValue(source), "file_name": bson.M{"$in": names}}
+	res, err := db.database.Collection(Collections.DeviceData).DeleteMany(ctx, dataFilter)
+	if err != nil {
+		return 0, err
+	}
+
+	update := bson.M{"$set": bson.M{"reprocess_requested": true}}
+	if _, err := files.UpdateMany(ctx, filter, update); err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
+
 func (db *MongoDB) SaveDeviceData(ctx context.Context, data []*models.DeviceData) error {
 	if len(data) == 0 {
 		return nil
@@ -176,6 +471,17 @@ func (db *MongoDB) SaveDeviceData(ctx context.Context, data []*models.DeviceData
 	return err
 }
 
+func (db *MongoDB) DeleteDeviceDataByFile(ctx context.Context, source, fileName, fileHash string) (int64, error) {
+	collection := db.database.Collection(Collections.DeviceData)
+
+	filter := bson.M{"source": sourceValue(source), "file_name": fileName, "file_hash": fileHash}
+	res, err := collection.DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
+
 func (db *MongoDB) SaveProcessingError(ctx context.Context, err *models.ProcessingError) error {
 	collection := db.database.Collection(Collections.ProcessingErrs)
 
@@ -187,6 +493,35 @@ func (db *MongoDB) SaveProcessingError(ctx context.Context, err *models.Processi
 	return errDb
 }
 
+func (db *MongoDB) SaveProcessingErrors(ctx context.Context, errs []*models.ProcessingError) error {
+	if len(errs) == 0 {
+		return nil
+	}
+
+	collection := db.database.Collection(Collections.ProcessingErrs)
+
+	documents := make([]interface{}, len(errs))
+	for i, e := range errs {
+		if e.ID.IsZero() {
+			e.ID = primitive.NewObjectID()
+		}
+		documents[i] = e
+	}
+
+	_, err := collection.InsertMany(ctx, documents)
+	return err
+}
+
+// DeleteRowErrors removes the row errors recorded for a version of a file,
+// leaving the errors of the file as a whole, which carry no hash.
+func (db *MongoDB) DeleteRowErrors(ctx context.Context, source, fileName, fileHash string) error {
+	collection := db.database.Collection(Collections.ProcessingErrs)
+
+	filter := bson.M{"source": sourceValue(source), "file_name": fileName, "file_hash": fileHash}
+	_, err := collection.DeleteMany(ctx, filter)
+	return err
+}
+
 func (db *MongoDB) GetDeviceDataByUnitGUID(ctx context.Context, unitGUID string, page, limit int64) (*models.PaginatedResponse, error) {
 	collection := db.database.Collection(Collections.DeviceData)
 
@@ -251,7 +586,8 @@ func (db *MongoDB) GetProcessingErrors(ctx context.Context, fileName string) ([]
 	collection := db.database.Collection(Collections.ProcessingErrs)
 
 	filter := bson.M{"file_name": fileName}
-	cursor, err := collection.Find(ctx, filter)
+	findOptions := options.Find().SetSort(bson.D{{Key: "row_num", Value: 1}})
+	cursor, err := collection.Find(ctx, filter, findOptions)
 	if err != nil {
 		return nil, err
 	}
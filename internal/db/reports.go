@@ -0,0 +1,170 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/tsv-processor/internal/config"
+	"github.com/tsv-processor/internal/models"
+)
+
+// RequestReport asks for the report of the device and file of req to be
+// generated with its template no earlier than window from now, on behalf of
+// a job if jobID is set. A request for the same device and template that is
+// still pending absorbs this one and keeps its due time, but takes its file.
+func (db *MongoDB) RequestReport(ctx context.Context, req *models.ReportRequest, jobID primitive.ObjectID, window time.Duration) error {
+	collection := db.database.Collection(Collections.ReportRequests)
+
+	now := time.Now()
+	filter := bson.M{"source": sourceValue(req.Source), "unit_guid": req.UnitGUID, "template": req.Template}
+	update := bson.M{
+		"$set":         bson.M{"file_name": req.FileName, "file_hash": req.FileHash},
+		"$setOnInsert": bson.M{"due_at": now.Add(window), "attempts": 0, "created_at": now},
+		"$inc":         bson.M{"requests": 1},
+	}
+	if !jobID.IsZero() {
+		update["$addToSet"] = bson.M{"job_ids": jobID}
+	}
+
+	_, err := collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		// Another instance inserted the request first; merge into it.
+		_, err = collection.UpdateOne(ctx, filter, update)
+	}
+	return err
+}
+
+// LeaseReport hands the oldest due report request to owner for the
+// visibility timeout. It returns nil when there is nothing to do.
+func (db *MongoDB) LeaseReport(ctx context.Context, owner string, visibility time.Duration) (*models.ReportRequest, error) {
+	collection := db.database.Collection(Collections.ReportRequests)
+
+	now := time.Now()
+	filter := bson.M{
+		"due_at":      bson.M{"$lte": now},
+		"lease_until": bson.M{"$not": bson.M{"$gt": now}},
+	}
+	update := bson.M{"$set": bson.M{"lease_owner": owner, "lease_until": now.Add(visibility)}}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "due_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var req models.ReportRequest
+	err := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&req)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &req, nil
+}
+
+// FinishReport removes a report request once it has been handled and records
+// the generated report, if any, on the jobs that asked for it. If the device
+// was requested again in the meantime, the request stays, due after window.
+func (db *MongoDB) FinishReport(ctx context.Context, req *models.ReportRequest, owner, reportPath string, window time.Duration) error {
+	if reportPath != "" && len(req.JobIDs) > 0 {
+		jobs := db.database.Collection(Collections.Jobs)
+		filter := bson.M{"_id": bson.M{"$in": req.JobIDs}}
+		update := bson.M{"$addToSet": bson.M{"stats.reports": reportPath}}
+		if _, err := jobs.UpdateMany(ctx, filter, update); err != nil {
+			return err
+		}
+	}
+
+	collection := db.database.Collection(Collections.ReportRequests)
+
+	res, err := collection.DeleteOne(ctx, bson.M{"_id": req.ID, "lease_owner": owner, "requests": req.Requests})
+	if err != nil || res.DeletedCount > 0 {
+		return err
+	}
+
+	update := bson.M{
+		"$set":   bson.M{"due_at": time.Now().Add(window), "attempts": 0},
+		"$inc":   bson.M{"requests": -req.Requests},
+		"$pull":  bson.M{"job_ids": bson.M{"$in": req.JobIDs}},
+		"$unset": bson.M{"lease_owner": "", "lease_until": "", "last_error": ""},
+	}
+	_, err = collection.UpdateOne(ctx, bson.M{"_id": req.ID, "lease_owner": owner}, update)
+	return err
+}
+
+// RetryReport returns a report request that failed to the queue, due at at.
+func (db *MongoDB) RetryReport(ctx context.Context, req *models.ReportRequest, owner string, at time.Time, lastErr string) error {
+	collection := db.database.Collection(Collections.ReportRequests)
+
+	filter := bson.M{"_id": req.ID, "lease_owner": owner}
+	update := bson.M{
+		"$set":   bson.M{"due_at": at, "last_error": lastErr},
+		"$inc":   bson.M{"attempts": 1},
+		"$unset": bson.M{"lease_owner": "", "lease_until": ""},
+	}
+
+	_, err := collection.UpdateOne(ctx, filter, update)
+	return err
+}
+
+// GetReportData returns every record of the device of req that a report of
+// the given scope covers, newest first: the records of the requested file,
+// the latest record of each msg_id, or all of them.
+func (db *MongoDB) GetReportData(ctx context.Context, req *models.ReportRequest, scope string) ([]models.DeviceData, error) {
+	collection := db.database.Collection(Collections.DeviceData)
+
+	filter := bson.M{"source": sourceValue(req.Source), "unit_guid": req.UnitGUID}
+	order := bson.D{{Key: "created_at", Value: -1}, {Key: "row_num", Value: 1}}
+
+	var cursor *mongo.Cursor
+	var err error
+	switch scope {
+	case config.ReportScopeFile:
+		filter["file_name"] = req.FileName
+		filter["file_hash"] = req.FileHash
+		cursor, err = collection.Find(ctx, filter, options.Find().SetSort(order))
+	case config.ReportScopeLatest:
+		pipeline := mongo.Pipeline{
+			{{Key: "$match", Value: filter}},
+			{{Key: "$sort", Value: bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}}},
+			{{Key: "$group", Value: bson.M{"_id": "$msg_id", "doc": bson.M{"$first": "$$ROOT"}}}},
+			{{Key: "$replaceRoot", Value: bson.M{"newRoot": "$doc"}}},
+			{{Key: "$sort", Value: order}},
+		}
+		cursor, err = collection.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	default:
+		cursor, err = collection.Find(ctx, filter, options.Find().SetSort(order))
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var data []models.DeviceData
+	if err := cursor.All(ctx, &data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// GetLatestDeviceFile returns the name and content hash of the file the
+// newest record of a device of a source came from.
+func (db *MongoDB) GetLatestDeviceFile(ctx context.Context, source, unitGUID string) (string, string, error) {
+	collection := db.database.Collection(Collections.DeviceData)
+
+	filter := bson.M{"source": sourceValue(source), "unit_guid": unitGUID}
+	opts := options.FindOne().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetProjection(bson.M{"file_name": 1, "file_hash": 1})
+
+	var latest models.DeviceData
+	if err := collection.FindOne(ctx, filter, opts).Decode(&latest); err != nil {
+		return "", "", err
+	}
+	return latest.FileName, latest.FileHash, nil
+}
@@ -0,0 +1,212 @@
+package generator
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// Charts are horizontal bar charts drawn side by side, one row of
+// chartRowHeight per bar below a title of chartTitleHeight.
+const (
+	chartRowHeight   = 6.0
+	chartTitleHeight = 8.0
+	chartGap         = 8.0
+	chartLabelWidth  = 30.0
+	chartCountWidth  = 12.0
+	// chartMaxBars caps the bars of a chart; the remaining values are
+	// grouped into one bar, or into ranges for levels.
+	chartMaxBars = 10
+)
+
+var defaultBarColor = rgb{70, 130, 180}
+
+type chartBar struct {
+	label string
+	count int
+	color rgb
+}
+
+type chart struct {
+	title string
+	bars  []chartBar
+}
+
+func (c chart) height() float64 {
+	return chartTitleHeight + float64(max(len(c.bars), 1))*chartRowHeight
+}
+
+// classChart counts the records per class, most frequent first, in the
+// colours of the classes.
+func (r *report) classChart() chart {
+	counts := make(map[string]int)
+	for _, d := range r.data {
+		counts[d.Class]++
+	}
+
+	classes := make([]string, 0, len(counts))
+	for class := range counts {
+		classes = append(classes, class)
+	}
+	sortByCount(classes, counts)
+
+	bars := make([]chartBar, 0, len(classes))
+	for _, class := range classes {
+		color, ok := r.tmpl.classColor(class)
+		if !ok {
+			color = defaultBarColor
+		}
+		bars = append(bars, chartBar{label: r.tmpl.classLabel(class, false), count: counts[class], color: color})
+	}
+
+	return chart{title: r.tmpl.Labels.ByClass, bars: groupRest(bars, r.tmpl.Labels.Other)}
+}
+
+// levelChart counts the records per level in ascending order. When there
+// are too many levels for one bar each, they are grouped into equal ranges.
+func (r *report) levelChart() chart {
+	counts := make(map[int]int)
+	for _, d := range r.data {
+		counts[d.Level]++
+	}
+
+	levels := make([]int, 0, len(counts))
+	for level := range counts {
+		levels = append(levels, level)
+	}
+	sort.Ints(levels)
+
+	var bars []chartBar
+	if len(levels) <= chartMaxBars {
+		for _, level := range levels {
+			bars = append(bars, chartBar{label: strconv.Itoa(level), count: counts[level], color: defaultBarColor})
+		}
+		return chart{title: r.tmpl.Labels.ByLevel, bars: bars}
+	}
+
+	lowest, highest := levels[0], levels[len(levels)-1]
+	step := (highest - lowest + chartMaxBars) / chartMaxBars
+	for from := lowest; from <= highest; from += step {
+		to := min(from+step-1, highest)
+		bar := chartBar{label: fmt.Sprintf("%d–%d", from, to), color: defaultBarColor}
+		for _, level := range levels {
+			if level >= from && level <= to {
+				bar.count += counts[level]
+			}
+		}
+		bars = append(bars, bar)
+	}
+	return chart{title: r.tmpl.Labels.ByLevel, bars: bars}
+}
+
+// areaChart counts the records per area, most frequent first.
+func (r *report) areaChart() chart {
+	counts := make(map[string]int)
+	for _, d := range r.data {
+		counts[d.Area]++
+	}
+
+	areas := make([]string, 0, len(counts))
+	for area := range counts {
+		areas = append(areas, area)
+	}
+	sortByCount(areas, counts)
+
+	bars := make([]chartBar, 0, len(areas))
+	for _, area := range areas {
+		bars = append(bars, chartBar{label: area, count: counts[area], color: defaultBarColor})
+	}
+
+	return chart{title: r.tmpl.Labels.ByArea, bars: groupRest(bars, r.tmpl.Labels.Other)}
+}
+
+func sortByCount(keys []string, counts map[string]int) {
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+}
+
+// groupRest keeps the first bars and sums up the others into a last bar.
+func groupRest(bars []chartBar, label string) []chartBar {
+	if len(bars) <= chartMaxBars {
+		return bars
+	}
+
+	rest := chartBar{label: label, color: rgb{192, 192, 192}}
+	for _, bar := range bars[chartMaxBars-1:] {
+		rest.count += bar.count
+	}
+	return append(bars[:chartMaxBars-1], rest)
+}
+
+// drawCharts draws the charts side by side at the current position, on a
+// new page if they do not fit on this one, and moves below them.
+func (r *report) drawCharts(charts []chart) {
+	if len(r.data) == 0 || len(charts) == 0 {
+		return
+	}
+
+	pdf := r.pdf
+
+	height := 0.0
+	for _, c := range charts {
+		height = max(height, c.height())
+	}
+	if !r.fits(height) {
+		pdf.AddPage()
+	}
+
+	left, top := pdf.GetX(), pdf.GetY()
+	width := (277 - chartGap*float64(len(charts)-1)) / float64(len(charts))
+	for i, c := range charts {
+		r.drawChart(c, left+float64(i)*(width+chartGap), top, width)
+	}
+
+	pdf.SetXY(left, top+height+4)
+}
+
+func (r *report) drawChart(c chart, x, y, width float64) {
+	pdf := r.pdf
+
+	pdf.SetXY(x, y)
+	pdf.SetFont("DejaVu", "B", 10)
+	pdf.CellFormat(width, chartTitleHeight-2, c.title, "", 0, "L", false, 0, "")
+
+	maxCount := 0
+	for _, bar := range c.bars {
+		maxCount = max(maxCount, bar.count)
+	}
+
+	barLeft := x + chartLabelWidth
+	barWidth := width - chartLabelWidth - chartCountWidth
+	top := y + chartTitleHeight
+
+	pdf.SetFont("DejaVu", "", 8)
+	pdf.SetLineWidth(0.2)
+	for i, bar := range c.bars {
+		rowTop := top + float64(i)*chartRowHeight
+
+		label := bar.label
+		if lines := pdf.SplitText(label, chartLabelWidth); len(lines) > 0 {
+			label = lines[0]
+		}
+		pdf.SetXY(x, rowTop)
+		pdf.CellFormat(chartLabelWidth, chartRowHeight, label, "", 0, "R", false, 0, "")
+
+		w := 0.0
+		if maxCount > 0 {
+			w = barWidth * float64(bar.count) / float64(maxCount)
+		}
+		pdf.SetFillColor(bar.color.R, bar.color.G, bar.color.B)
+		pdf.Rect(barLeft, rowTop+1, w, chartRowHeight-2, "F")
+
+		pdf.SetXY(barLeft+w, rowTop)
+		pdf.CellFormat(chartCountWidth, chartRowHeight, strconv.Itoa(bar.count), "", 0, "L", false, 0, "")
+	}
+
+	pdf.SetDrawColor(0, 0, 0)
+	pdf.Line(barLeft, top, barLeft, top+float64(max(len(c.bars), 1))*chartRowHeight)
+}
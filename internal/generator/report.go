@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/jung-kurt/gofpdf/v2"
+	"github.com/tsv-processor/internal/config"
 	"github.com/tsv-processor/internal/models"
 )
 
@@ -19,96 +20,178 @@ func NewReportGenerator(outputDir string) *ReportGenerator {
 	}
 }
 
-func (g *ReportGenerator) GeneratePDF(unitGUID string, data []models.DeviceData) (string, error) {
+// ReportOptions describes how the data of a report was selected and how it
+// is laid out.
+type ReportOptions struct {
+	// Scope is one of the config.ReportScope values; FileName is the file
+	// a report of the file scope was built from.
+	Scope    string
+	FileName string
+	// MaxRows limits the rows of the detail table; zero means no limit.
+	MaxRows int
+	// Template is the layout of the report; nil means the default one.
+	Template *Template
+}
+
+// report is a device report being drawn.
+type report struct {
+	pdf         *gofpdf.Fpdf
+	tmpl        *Template
+	unitGUID    string
+	data        []models.DeviceData
+	opts        ReportOptions
+	generatedAt time.Time
+}
+
+func (g *ReportGenerator) GeneratePDF(unitGUID string, data []models.DeviceData, opts ReportOptions) (string, error) {
+	r := &report{
+		tmpl:        opts.Template,
+		unitGUID:    unitGUID,
+		data:        data,
+		opts:        opts,
+		generatedAt: time.Now(),
+	}
+	if r.tmpl == nil {
+		r.tmpl = DefaultTemplate()
+	}
+
 	pdf := gofpdf.New("L", "mm", "A4", "")
+	r.pdf = pdf
+	pdf.SetAutoPageBreak(true, 15)
+	pdf.AliasNbPages("")
+	pdf.SetFooterFunc(r.drawFooter)
 	pdf.AddPage()
 
 	pdf.AddUTF8Font("DejaVu", "", "./fonts/DejaVuSans.ttf")
 	pdf.AddUTF8Font("DejaVu", "B", "./fonts/DejaVuSans-Bold.ttf")
 	pdf.SetFont("DejaVu", "", 12)
 
+	for _, section := range r.tmpl.Sections {
+		switch section {
+		case SectionHeader:
+			r.drawHeader()
+		case SectionDeviceInfo:
+			r.drawDeviceInfo()
+		case SectionStatistics:
+			r.drawStatistics()
+		case SectionDetails:
+			r.drawDetails()
+		}
+	}
+
+	fileName := fmt.Sprintf("device_%s_%s.pdf", unitGUID, r.generatedAt.Format("20060102_150405"))
+	if r.tmpl.Name != "" && r.tmpl.Name != "default" {
+		fileName = fmt.Sprintf("device_%s_%s_%s.pdf", unitGUID, r.tmpl.Name, r.generatedAt.Format("20060102_150405"))
+	}
+	filePath := filepath.Join(g.outputDir, fileName)
+
+	err := pdf.OutputFileAndClose(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to save PDF: %w", err)
+	}
+
+	return filePath, nil
+}
+
+// fits reports whether a block of the given height still fits above the
+// bottom margin of the current page.
+func (r *report) fits(height float64) bool {
+	_, pageHeight := r.pdf.GetPageSize()
+	_, _, _, bottomMargin := r.pdf.GetMargins()
+	return r.pdf.GetY()+height <= pageHeight-bottomMargin
+}
+
+func (r *report) scopeLabel() string {
+	labels := r.tmpl.Labels
+	switch r.opts.Scope {
+	case config.ReportScopeFile:
+		return fmt.Sprintf("%s: %s %s", labels.Scope, labels.ScopeFile, r.opts.FileName)
+	case config.ReportScopeLatest:
+		return fmt.Sprintf("%s: %s", labels.Scope, labels.ScopeLatest)
+	default:
+		return fmt.Sprintf("%s: %s", labels.Scope, labels.ScopeHistory)
+	}
+}
+
+func (r *report) drawFooter() {
+	pdf := r.pdf
+	labels := r.tmpl.Labels
+
+	pdf.SetY(-12)
+	pdf.SetFont("DejaVu", "", 8)
+	pdf.SetTextColor(128, 128, 128)
+	pdf.CellFormat(92, 6, fmt.Sprintf("%s: %s", labels.DeviceGUID, r.unitGUID), "", 0, "L", false, 0, "")
+	pdf.CellFormat(93, 6, fmt.Sprintf("%s: %s", labels.Generated, r.generatedAt.Format("2006-01-02 15:04:05")), "", 0, "C", false, 0, "")
+	pdf.CellFormat(92, 6, fmt.Sprintf("%s %d %s {nb}", labels.Page, pdf.PageNo(), labels.Of), "", 0, "R", false, 0, "")
+	pdf.SetTextColor(0, 0, 0)
+}
+
+func (r *report) drawHeader() {
+	pdf := r.pdf
+	labels := r.tmpl.Labels
+
 	pdf.SetFont("DejaVu", "B", 16)
-	pdf.CellFormat(277, 10, fmt.Sprintf("GUID устройства: %s", unitGUID), "", 0, "C", false, 0, "")
+	pdf.CellFormat(277, 10, fmt.Sprintf("%s: %s", labels.DeviceGUID, r.unitGUID), "", 0, "C", false, 0, "")
 	pdf.Ln(12)
 
 	pdf.SetFont("DejaVu", "", 10)
-	pdf.CellFormat(277, 6, fmt.Sprintf("Дата отчета: %s", time.Now().Format("2006-01-02 15:04:05")), "", 0, "C", false, 0, "")
-	pdf.Ln(15)
-
-	if len(data) > 0 {
-		pdf.SetFont("DejaVu", "B", 14)
-		pdf.Cell(277, 8, "Информация об устройстве")
-		pdf.Ln(10)
-
-		pdf.SetFont("DejaVu", "", 11)
-		inventory := data[0].Inventory
-		pdf.Cell(277, 7, fmt.Sprintf("Инвентарный номер: %s", inventory))
-		pdf.Ln(7)
+	pdf.CellFormat(277, 6, fmt.Sprintf("%s: %s", labels.ReportDate, r.generatedAt.Format("2006-01-02 15:04:05")), "", 0, "C", false, 0, "")
+	pdf.Ln(6)
+	pdf.CellFormat(277, 6, r.scopeLabel(), "", 0, "C", false, 0, "")
+	pdf.Ln(9)
+}
 
-		pdf.Cell(277, 7, fmt.Sprintf("Всего записей: %d", len(data)))
-		pdf.Ln(12)
+func (r *report) drawDeviceInfo() {
+	if len(r.data) == 0 {
+		return
 	}
 
+	pdf := r.pdf
+	labels := r.tmpl.Labels
+
 	pdf.SetFont("DejaVu", "B", 14)
-	pdf.Cell(277, 8, "Статистика сообщений")
+	pdf.Cell(277, 8, labels.DeviceInfo)
 	pdf.Ln(10)
 
 	pdf.SetFont("DejaVu", "", 11)
-
-	classCount := make(map[string]int)
-	uniqueMsgIDs := make(map[string]bool)
-	for _, d := range data {
-		classCount[d.Class]++
-		uniqueMsgIDs[d.MsgID] = true
-	}
-
-	pdf.Cell(277, 7, fmt.Sprintf("Уникальных ID сообщений: %d", len(uniqueMsgIDs)))
+	inventory := r.data[0].Inventory
+	pdf.Cell(277, 7, fmt.Sprintf("%s: %s", labels.Inventory, inventory))
 	pdf.Ln(7)
 
-	for class, count := range classCount {
-		className := class
-		switch class {
-		case "alarm":
-			className = "Авария"
-			pdf.SetTextColor(255, 0, 0)
-		case "warning":
-			className = "Предупреждение"
-			pdf.SetTextColor(255, 165, 0)
-		case "working":
-			className = "Работа"
-			pdf.SetTextColor(0, 128, 0)
-		case "waiting":
-			className = "Ожидание"
-			pdf.SetTextColor(128, 128, 128)
-		}
+	pdf.Cell(277, 7, fmt.Sprintf("%s: %d", labels.TotalRecords, len(r.data)))
+	pdf.Ln(12)
+}
 
-		pdf.Cell(277, 7, fmt.Sprintf("• %s: %d", className, count))
-		pdf.Ln(7)
-		pdf.SetTextColor(0, 0, 0)
-	}
-	pdf.Ln(8)
+func (r *report) drawStatistics() {
+	pdf := r.pdf
+	labels := r.tmpl.Labels
 
 	pdf.SetFont("DejaVu", "B", 14)
-	pdf.Cell(277, 8, "Детальная информация")
-	pdf.Ln(12)
-
-	pdf.SetFont("DejaVu", "B", 8)
-	pdf.SetFillColor(240, 240, 240)
+	pdf.Cell(277, 8, labels.Statistics)
+	pdf.Ln(10)
 
-	colWidths := []float64{8, 60, 60, 20, 15, 15, 99}
-	headers := []string{"№", "ID сообщения", "Текст", "Класс", "Уровень", "Зона", "Адрес"}
+	pdf.SetFont("DejaVu", "", 11)
 
-	for i, header := range headers {
-		pdf.CellFormat(colWidths[i], 8, header, "1", 0, "C", true, 0, "")
+	uniqueMsgIDs := make(map[string]bool)
+	for _, d := range r.data {
+		uniqueMsgIDs[d.MsgID] = true
 	}
-	pdf.Ln(-1)
 
-	pdf.SetFont("DejaVu", "", 7)
+	pdf.Cell(277, 7, fmt.Sprintf("%s: %d", labels.UniqueMsgIDs, len(uniqueMsgIDs)))
+	pdf.Ln(10)
+
+	r.drawCharts([]chart{r.classChart(), r.levelChart(), r.areaChart()})
+	pdf.Ln(4)
+}
+
+func (r *report) drawDetails() {
+	pdf := r.pdf
+	labels := r.tmpl.Labels
 
 	seen := make(map[string]bool)
 	uniqueData := []models.DeviceData{}
 
-	for _, d := range data {
+	for _, d := range r.data {
 		key := fmt.Sprintf("%d-%s-%s", d.RowNum, d.MsgID, d.Addr)
 		if !seen[key] {
 			seen[key] = true
@@ -116,56 +199,31 @@ func (g *ReportGenerator) GeneratePDF(unitGUID string, data []models.DeviceData)
 		}
 	}
 
-	for i, d := range uniqueData {
-		if i >= 30 {
-			pdf.SetFont("DejaVu", "I", 7)
-			pdf.CellFormat(277, 5, "... и еще записи", "", 0, "C", false, 0, "")
-			pdf.Ln(5)
-			break
-		}
+	shown := uniqueData
+	if r.opts.MaxRows > 0 && len(shown) > r.opts.MaxRows {
+		shown = shown[:r.opts.MaxRows]
+	}
 
-		msgID := d.MsgID
-		textMsg := d.Text
-		addr := d.Addr
-
-		className := d.Class
-		switch d.Class {
-		case "alarm":
-			className = "Авария"
-			pdf.SetTextColor(255, 0, 0)
-		case "warning":
-			className = "Предупр."
-			pdf.SetTextColor(255, 165, 0)
-		case "working":
-			className = "Работа"
-			pdf.SetTextColor(0, 128, 0)
-		case "waiting":
-			className = "Ожидание"
-			pdf.SetTextColor(128, 128, 128)
-		}
+	detail := newTable(pdf, r.tmpl.tableColumns(), shown, 277)
+	if !r.fits(8 + 12 + detail.headerHeight() + tableLineHeight + 2*tablePadding) {
+		pdf.AddPage()
+	}
 
-		pdf.CellFormat(colWidths[0], 6, fmt.Sprintf("%d", d.RowNum), "1", 0, "C", false, 0, "")
-		pdf.CellFormat(colWidths[1], 6, msgID, "1", 0, "L", false, 0, "")
-		pdf.CellFormat(colWidths[2], 6, textMsg, "1", 0, "L", false, 0, "")
-		pdf.CellFormat(colWidths[3], 6, className, "1", 0, "C", false, 0, "")
-		pdf.SetTextColor(0, 0, 0)
-		pdf.CellFormat(colWidths[4], 6, fmt.Sprintf("%d", d.Level), "1", 0, "C", false, 0, "")
-		pdf.CellFormat(colWidths[5], 6, d.Area, "1", 0, "C", false, 0, "")
-		pdf.CellFormat(colWidths[6], 6, addr, "1", 0, "L", false, 0, "")
-		pdf.Ln(-1)
+	pdf.SetFont("DejaVu", "B", 14)
+	pdf.Cell(277, 8, labels.Details)
+	pdf.Ln(12)
+
+	detail.drawHeader()
+	for _, d := range shown {
+		detail.drawRow(d, r.fits)
 	}
 
 	pdf.Ln(5)
 	pdf.SetFont("DejaVu", "B", 10)
-	pdf.CellFormat(277, 7, fmt.Sprintf("Всего записей: %d", len(uniqueData)), "", 0, "R", false, 0, "")
-
-	fileName := fmt.Sprintf("device_%s_%s.pdf", unitGUID, time.Now().Format("20060102_150405"))
-	filePath := filepath.Join(g.outputDir, fileName)
-
-	err := pdf.OutputFileAndClose(filePath)
-	if err != nil {
-		return "", fmt.Errorf("failed to save PDF: %w", err)
+	total := fmt.Sprintf("%s: %d", labels.TotalRecords, len(uniqueData))
+	if len(shown) < len(uniqueData) {
+		total = fmt.Sprintf("%s: %d %s %d", labels.ShownRecords, len(shown), labels.Of, len(uniqueData))
 	}
-
-	return filePath, nil
+	pdf.CellFormat(277, 7, total, "", 0, "R", false, 0, "")
+	pdf.Ln(7)
 }
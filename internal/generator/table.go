@@ -0,0 +1,239 @@
+package generator
+
+import (
+	"github.com/jung-kurt/gofpdf/v2"
+	"github.com/tsv-processor/internal/models"
+)
+
+// Text of the detail table is wrapped to lines of tableLineHeight with
+// tablePadding above and below, so that a single-line row is as high as
+// the rows of fixed height used to be.
+const (
+	tableLineHeight = 4.0
+	tablePadding    = 1.0
+	headerPadding   = 2.0
+)
+
+type rgb struct {
+	R, G, B int
+}
+
+// tableColumn is a column of the detail table. Its width follows its widest
+// value within minWidth and maxWidth; wrapped columns then share whatever is
+// left of the page width, or give it back.
+type tableColumn struct {
+	header   string
+	minWidth float64
+	maxWidth float64
+	align    string
+	wrap     bool
+	value    func(d models.DeviceData) string
+	// color, if set, returns the text color of a value.
+	color func(d models.DeviceData) (rgb, bool)
+}
+
+// table draws the rows of the detail table with wrapped cells, starting a
+// new page with the column headers whenever a row does not fit.
+type table struct {
+	pdf     *gofpdf.Fpdf
+	columns []tableColumn
+	widths  []float64
+}
+
+func newTable(pdf *gofpdf.Fpdf, columns []tableColumn, rows []models.DeviceData, width float64) *table {
+	return &table{
+		pdf:     pdf,
+		columns: columns,
+		widths:  fitColumnWidths(pdf, columns, rows, width),
+	}
+}
+
+// fitColumnWidths sizes each column to its widest value within its limits
+// and then stretches or shrinks the wrapped columns so that the table is
+// exactly width wide. Shrinking stops at the minimum widths.
+func fitColumnWidths(pdf *gofpdf.Fpdf, columns []tableColumn, rows []models.DeviceData, width float64) []float64 {
+	cellMargin := pdf.GetCellMargin()
+
+	widths := make([]float64, len(columns))
+	pdf.SetFont("DejaVu", "B", 8)
+	for i, col := range columns {
+		widths[i] = pdf.GetStringWidth(col.header) + 2*cellMargin
+	}
+	pdf.SetFont("DejaVu", "", 7)
+	for _, d := range rows {
+		for i, col := range columns {
+			if w := pdf.GetStringWidth(col.value(d)) + 2*cellMargin; w > widths[i] {
+				widths[i] = w
+			}
+		}
+	}
+
+	total := 0.0
+	for i, col := range columns {
+		widths[i] = min(max(widths[i], col.minWidth), col.maxWidth)
+		total += widths[i]
+	}
+
+	var flexible, slack float64
+	for i, col := range columns {
+		if col.wrap {
+			flexible += widths[i]
+			slack += widths[i] - col.minWidth
+		}
+	}
+
+	diff := width - total
+	for i, col := range columns {
+		switch {
+		case !col.wrap:
+		case diff > 0 && flexible > 0:
+			widths[i] += diff * widths[i] / flexible
+		case diff < 0 && slack > 0:
+			widths[i] += max(diff, -slack) * (widths[i] - col.minWidth) / slack
+		}
+	}
+
+	return widths
+}
+
+// layout splits the values of a row into the lines that fit their columns
+// and returns them with the height of the row.
+func (t *table) layout(values []string, padding float64) ([][]string, float64) {
+	lines := make([][]string, len(values))
+	for i, v := range values {
+		lines[i] = t.pdf.SplitText(v, t.widths[i])
+		if len(lines[i]) == 0 {
+			lines[i] = []string{""}
+		}
+	}
+	return lines, linesHeight(lines, padding)
+}
+
+// linesHeight returns the height of a row showing the given lines.
+func linesHeight(lines [][]string, padding float64) float64 {
+	height := 0.0
+	for _, cellLines := range lines {
+		height = max(height, float64(len(cellLines))*tableLineHeight+2*padding)
+	}
+	return height
+}
+
+// splitLines cuts the first n lines of every cell off a laid out row.
+func splitLines(lines [][]string, n int) (head, rest [][]string) {
+	head = make([][]string, len(lines))
+	rest = make([][]string, len(lines))
+	for i, cellLines := range lines {
+		k := min(n, len(cellLines))
+		head[i], rest[i] = cellLines[:k], cellLines[k:]
+	}
+	return head, rest
+}
+
+// drawCells draws a laid out row at the current position and moves below it.
+// Header cells are filled and centred.
+func (t *table) drawCells(lines [][]string, height, padding float64, header bool, colors []*rgb) {
+	pdf := t.pdf
+	left, top := pdf.GetX(), pdf.GetY()
+
+	style := "D"
+	if header {
+		style = "FD"
+	}
+
+	x := left
+	for i, cellLines := range lines {
+		pdf.Rect(x, top, t.widths[i], height, style)
+
+		if colors != nil && colors[i] != nil {
+			pdf.SetTextColor(colors[i].R, colors[i].G, colors[i].B)
+		}
+		for j, line := range cellLines {
+			pdf.SetXY(x, top+padding+float64(j)*tableLineHeight)
+			align := t.columns[i].align
+			if header {
+				align = "C"
+			}
+			pdf.CellFormat(t.widths[i], tableLineHeight, line, "", 0, align, false, 0, "")
+		}
+		pdf.SetTextColor(0, 0, 0)
+
+		x += t.widths[i]
+	}
+
+	pdf.SetXY(left, top+height)
+}
+
+// layoutHeader lays out the column headers in the header font, which stays
+// selected.
+func (t *table) layoutHeader() ([][]string, float64) {
+	t.pdf.SetFont("DejaVu", "B", 8)
+
+	headers := make([]string, len(t.columns))
+	for i, col := range t.columns {
+		headers[i] = col.header
+	}
+	return t.layout(headers, headerPadding)
+}
+
+func (t *table) headerHeight() float64 {
+	_, height := t.layoutHeader()
+	t.pdf.SetFont("DejaVu", "", 7)
+	return height
+}
+
+// pageRoom returns the height a new page has for rows below the column
+// headers.
+func (t *table) pageRoom() float64 {
+	_, pageHeight := t.pdf.GetPageSize()
+	_, top, _, bottom := t.pdf.GetMargins()
+	return pageHeight - top - bottom - t.headerHeight()
+}
+
+func (t *table) drawHeader() {
+	lines, height := t.layoutHeader()
+	t.pdf.SetFillColor(240, 240, 240)
+	t.drawCells(lines, height, headerPadding, true, nil)
+	t.pdf.SetFont("DejaVu", "", 7)
+}
+
+// drawRow draws the row of one record, first starting a new page if it does
+// not fit on this one. A row taller than a whole page is split instead, with
+// as many of its lines as fit on each page. fits reports whether a block of
+// a given height fits above the bottom margin.
+func (t *table) drawRow(d models.DeviceData, fits func(height float64) bool) {
+	values := make([]string, len(t.columns))
+	colors := make([]*rgb, len(t.columns))
+	for i, col := range t.columns {
+		values[i] = col.value(d)
+		if col.color != nil {
+			if c, ok := col.color(d); ok {
+				colors[i] = &c
+			}
+		}
+	}
+
+	lines, height := t.layout(values, tablePadding)
+	if !fits(height) && height <= t.pageRoom() {
+		t.pdf.AddPage()
+		t.drawHeader()
+	}
+
+	for newPage := false; !fits(height); newPage = true {
+		n := 0
+		for fits(float64(n+1)*tableLineHeight + 2*tablePadding) {
+			n++
+		}
+		if n == 0 && newPage {
+			break // not even one line fits on a page
+		}
+		if n > 0 {
+			var head [][]string
+			head, lines = splitLines(lines, n)
+			t.drawCells(head, linesHeight(head, tablePadding), tablePadding, false, colors)
+			height = linesHeight(lines, tablePadding)
+		}
+		t.pdf.AddPage()
+		t.drawHeader()
+	}
+	t.drawCells(lines, height, tablePadding, false, colors)
+}
@@ -0,0 +1,124 @@
+package generator
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/jung-kurt/gofpdf/v2"
+	"github.com/tsv-processor/internal/models"
+)
+
+func newTestPDF(t *testing.T) *gofpdf.Fpdf {
+	t.Helper()
+	pdf := gofpdf.New("L", "mm", "A4", "")
+	pdf.AddUTF8Font("DejaVu", "", "../../fonts/DejaVuSans.ttf")
+	pdf.AddUTF8Font("DejaVu", "B", "../../fonts/DejaVuSans-Bold.ttf")
+	if err := pdf.Error(); err != nil {
+		t.Fatal(err)
+	}
+	return pdf
+}
+
+func textColumn(header string, minWidth, maxWidth float64, wrap bool) tableColumn {
+	return tableColumn{
+		header:   header,
+		minWidth: minWidth,
+		maxWidth: maxWidth,
+		wrap:     wrap,
+		value:    func(d models.DeviceData) string { return d.Text },
+	}
+}
+
+func TestFitColumnWidths(t *testing.T) {
+	short := []models.DeviceData{{Text: "ok"}}
+	long := []models.DeviceData{{Text: strings.Repeat("a long message text ", 20)}}
+
+	tests := []struct {
+		name    string
+		columns []tableColumn
+		rows    []models.DeviceData
+		width   float64
+		want    []float64
+	}{
+		{
+			name:    "fixed columns keep their minimum",
+			columns: []tableColumn{textColumn("a", 10, 20, false), textColumn("b", 15, 15, false)},
+			rows:    short,
+			width:   100,
+			want:    []float64{10, 15},
+		},
+		{
+			name:    "fixed columns stop at their maximum",
+			columns: []tableColumn{textColumn("a", 10, 20, false)},
+			rows:    long,
+			width:   100,
+			want:    []float64{20},
+		},
+		{
+			name:    "wrapped columns share the rest",
+			columns: []tableColumn{textColumn("a", 10, 10, false), textColumn("b", 20, 100, true), textColumn("c", 20, 100, true)},
+			rows:    short,
+			width:   100,
+			want:    []float64{10, 45, 45},
+		},
+		{
+			name:    "wrapped columns shrink to fit",
+			columns: []tableColumn{textColumn("a", 10, 10, false), textColumn("b", 20, 100, true)},
+			rows:    long,
+			width:   60,
+			want:    []float64{10, 50},
+		},
+		{
+			name:    "shrinking stops at the minimum",
+			columns: []tableColumn{textColumn("a", 10, 10, false), textColumn("b", 40, 100, true)},
+			rows:    long,
+			width:   30,
+			want:    []float64{10, 40},
+		},
+		{
+			name:    "no wrapped columns to stretch",
+			columns: []tableColumn{textColumn("a", 10, 10, false)},
+			rows:    nil,
+			width:   100,
+			want:    []float64{10},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := fitColumnWidths(newTestPDF(t), tt.columns, tt.rows, tt.width)
+			if len(got) != len(tt.want) {
+				t.Fatalf("widths = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if math.Abs(got[i]-tt.want[i]) > 1e-6 {
+					t.Errorf("widths = %v, want %v", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestDrawRowSplitsTallRows(t *testing.T) {
+	pdf := newTestPDF(t)
+	pdf.SetAutoPageBreak(true, 15)
+	pdf.AddPage()
+
+	rows := []models.DeviceData{{Text: strings.Repeat("word ", 3000)}}
+	tbl := newTable(pdf, []tableColumn{textColumn("text", 50, 50, true)}, rows, 50)
+	fits := func(height float64) bool {
+		_, pageHeight := pdf.GetPageSize()
+		return pdf.GetY()+height <= pageHeight-15
+	}
+
+	tbl.drawHeader()
+	tbl.drawRow(rows[0], fits)
+
+	lines, _ := tbl.layout([]string{rows[0].Text}, tablePadding)
+	perPage := int(tbl.pageRoom() / tableLineHeight)
+	if maxPages := len(lines[0])/perPage + 2; pdf.PageNo() > maxPages {
+		t.Errorf("a row of %d lines took %d pages, want at most %d", len(lines[0]), pdf.PageNo(), maxPages)
+	}
+}
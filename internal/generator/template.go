@@ -0,0 +1,291 @@
+package generator
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/tsv-processor/internal/models"
+)
+
+const (
+	SectionHeader     = "header"
+	SectionDeviceInfo = "device_info"
+	SectionStatistics = "statistics"
+	SectionDetails    = "details"
+)
+
+// Template describes the layout of a device report. A template file only
+// needs the settings it changes: anything left out is taken from the
+// default template, and classes are merged with the default ones. Name is
+// that of the file and appears in the names of the reports.
+type Template struct {
+	Name     string                `yaml:"-" json:"-"`
+	Sections []string              `yaml:"sections" json:"sections"`
+	Columns  []Column              `yaml:"columns" json:"columns"`
+	Classes  map[string]ClassStyle `yaml:"classes" json:"classes"`
+	Labels   Labels                `yaml:"labels" json:"labels"`
+}
+
+// Column is a column of the detail table showing a DeviceData field, named
+// as in the input files (row_num, msg_id, text, ...). Width fixes the width;
+// otherwise the column follows its content between MinWidth and MaxWidth,
+// and wrapped columns share the rest of the page.
+type Column struct {
+	Field    string  `yaml:"field" json:"field"`
+	Label    string  `yaml:"label" json:"label"`
+	Width    float64 `yaml:"width" json:"width"`
+	MinWidth float64 `yaml:"min_width" json:"min_width"`
+	MaxWidth float64 `yaml:"max_width" json:"max_width"`
+	Align    string  `yaml:"align" json:"align"`
+	Wrap     bool    `yaml:"wrap" json:"wrap"`
+}
+
+// ClassStyle is how a message class is shown: Label in the statistics,
+// ShortLabel in the detail table and Color, as "#rrggbb", in both.
+type ClassStyle struct {
+	Label      string `yaml:"label" json:"label"`
+	ShortLabel string `yaml:"short_label" json:"short_label"`
+	Color      string `yaml:"color" json:"color"`
+}
+
+// Labels are the fixed texts of a report.
+type Labels struct {
+	DeviceGUID   string `yaml:"device_guid" json:"device_guid"`
+	ReportDate   string `yaml:"report_date" json:"report_date"`
+	Scope        string `yaml:"scope" json:"scope"`
+	ScopeFile    string `yaml:"scope_file" json:"scope_file"`
+	ScopeLatest  string `yaml:"scope_latest" json:"scope_latest"`
+	ScopeHistory string `yaml:"scope_history" json:"scope_history"`
+	DeviceInfo   string `yaml:"device_info" json:"device_info"`
+	Inventory    string `yaml:"inventory" json:"inventory"`
+	TotalRecords string `yaml:"total_records" json:"total_records"`
+	ShownRecords string `yaml:"shown_records" json:"shown_records"`
+	Statistics   string `yaml:"statistics" json:"statistics"`
+	UniqueMsgIDs string `yaml:"unique_msg_ids" json:"unique_msg_ids"`
+	ByClass      string `yaml:"by_class" json:"by_class"`
+	ByLevel      string `yaml:"by_level" json:"by_level"`
+	ByArea       string `yaml:"by_area" json:"by_area"`
+	Other        string `yaml:"other" json:"other"`
+	Details      string `yaml:"details" json:"details"`
+	Generated    string `yaml:"generated" json:"generated"`
+	Page         string `yaml:"page" json:"page"`
+	Of           string `yaml:"of" json:"of"`
+}
+
+// DefaultTemplate returns the layout reports had before templates existed.
+func DefaultTemplate() *Template {
+	return &Template{
+		Name:     "default",
+		Sections: []string{SectionHeader, SectionDeviceInfo, SectionStatistics, SectionDetails},
+		Columns: []Column{
+			{Field: "row_num", Label: "№", MinWidth: 8, MaxWidth: 14, Align: "C"},
+			{Field: "msg_id", Label: "ID сообщения", MinWidth: 25, MaxWidth: 70, Wrap: true},
+			{Field: "text", Label: "Текст", MinWidth: 40, MaxWidth: 120, Wrap: true},
+			{Field: "class", Label: "Класс", MinWidth: 16, MaxWidth: 26, Align: "C"},
+			{Field: "level", Label: "Уровень", MinWidth: 14, MaxWidth: 18, Align: "C"},
+			{Field: "area", Label: "Зона", MinWidth: 12, MaxWidth: 16, Align: "C"},
+			{Field: "addr", Label: "Адрес", MinWidth: 30, MaxWidth: 100, Wrap: true},
+		},
+		Classes: map[string]ClassStyle{
+			"alarm":   {Label: "Авария", Color: "#ff0000"},
+			"warning": {Label: "Предупреждение", ShortLabel: "Предупр.", Color: "#ffa500"},
+			"working": {Label: "Работа", Color: "#008000"},
+			"waiting": {Label: "Ожидание", Color: "#808080"},
+		},
+		Labels: Labels{
+			DeviceGUID:   "GUID устройства",
+			ReportDate:   "Дата отчета",
+			Scope:        "Область отчета",
+			ScopeFile:    "файл",
+			ScopeLatest:  "последняя версия каждого сообщения",
+			ScopeHistory: "вся история",
+			DeviceInfo:   "Информация об устройстве",
+			Inventory:    "Инвентарный номер",
+			TotalRecords: "Всего записей",
+			ShownRecords: "Показано записей",
+			Statistics:   "Статистика сообщений",
+			UniqueMsgIDs: "Уникальных ID сообщений",
+			ByClass:      "По классам",
+			ByLevel:      "По уровням",
+			ByArea:       "По зонам",
+			Other:        "Прочие",
+			Details:      "Детальная информация",
+			Generated:    "Сформирован",
+			Page:         "Страница",
+			Of:           "из",
+		},
+	}
+}
+
+// LoadTemplate reads a template from a YAML or JSON file, chosen by its
+// extension, on top of the default template.
+func LoadTemplate(path string) (*Template, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read report template: %w", err)
+	}
+
+	defaults := DefaultTemplate()
+	tmpl := DefaultTemplate()
+	tmpl.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+
+	// Lists replace the default ones as a whole: decoding JSON into them
+	// would overwrite the default entries one by one instead.
+	tmpl.Sections, tmpl.Columns = nil, nil
+
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(data, tmpl)
+	} else {
+		err = yaml.Unmarshal(data, tmpl)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse report template %s: %w", path, err)
+	}
+
+	if tmpl.Sections == nil {
+		tmpl.Sections = defaults.Sections
+	}
+	if tmpl.Columns == nil {
+		tmpl.Columns = defaults.Columns
+	}
+
+	if err := tmpl.validate(); err != nil {
+		return nil, fmt.Errorf("report template %s: %w", path, err)
+	}
+	return tmpl, nil
+}
+
+func (t *Template) validate() error {
+	for _, section := range t.Sections {
+		switch section {
+		case SectionHeader, SectionDeviceInfo, SectionStatistics, SectionDetails:
+		default:
+			return fmt.Errorf("unknown section %q", section)
+		}
+	}
+
+	for i, col := range t.Columns {
+		if _, ok := fieldValues[col.Field]; !ok {
+			return fmt.Errorf("column %d: unknown field %q", i+1, col.Field)
+		}
+		switch col.Align {
+		case "", "L", "C", "R":
+		default:
+			return fmt.Errorf("column %s: align must be L, C or R", col.Field)
+		}
+		if col.Width < 0 || col.MinWidth < 0 || col.MaxWidth < 0 {
+			return fmt.Errorf("column %s: widths must not be negative", col.Field)
+		}
+		if col.MaxWidth > 0 && col.MinWidth > col.MaxWidth {
+			return fmt.Errorf("column %s: min_width is greater than max_width", col.Field)
+		}
+	}
+
+	for class, style := range t.Classes {
+		if style.Color == "" {
+			continue
+		}
+		if _, err := parseColor(style.Color); err != nil {
+			return fmt.Errorf("class %s: %w", class, err)
+		}
+	}
+
+	return nil
+}
+
+// fieldValues renders the DeviceData fields that columns may show.
+var fieldValues = map[string]func(d models.DeviceData) string{
+	"row_num":    func(d models.DeviceData) string { return strconv.Itoa(d.RowNum) },
+	"mqtt":       func(d models.DeviceData) string { return d.MQTT },
+	"invid":      func(d models.DeviceData) string { return d.Inventory },
+	"unit_guid":  func(d models.DeviceData) string { return d.UnitGUID },
+	"msg_id":     func(d models.DeviceData) string { return d.MsgID },
+	"text":       func(d models.DeviceData) string { return d.Text },
+	"context":    func(d models.DeviceData) string { return d.Context },
+	"class":      func(d models.DeviceData) string { return d.Class },
+	"level":      func(d models.DeviceData) string { return strconv.Itoa(d.Level) },
+	"area":       func(d models.DeviceData) string { return d.Area },
+	"addr":       func(d models.DeviceData) string { return d.Addr },
+	"block":      func(d models.DeviceData) string { return d.Block },
+	"type":       func(d models.DeviceData) string { return d.Type },
+	"bit":        func(d models.DeviceData) string { return strconv.Itoa(d.Bit) },
+	"invert_bit": func(d models.DeviceData) string { return strconv.Itoa(d.InvertBit) },
+	"file_name":  func(d models.DeviceData) string { return d.FileName },
+	"site":       func(d models.DeviceData) string { return d.Site },
+	"created_at": func(d models.DeviceData) string { return d.CreatedAt.Format("2006-01-02 15:04:05") },
+}
+
+// classLabel returns the label of a class, short for the detail table, or
+// the class itself if the template has none.
+func (t *Template) classLabel(class string, short bool) string {
+	style, ok := t.Classes[class]
+	if short && style.ShortLabel != "" {
+		return style.ShortLabel
+	}
+	if ok && style.Label != "" {
+		return style.Label
+	}
+	return class
+}
+
+func (t *Template) classColor(class string) (rgb, bool) {
+	style, ok := t.Classes[class]
+	if !ok || style.Color == "" {
+		return rgb{}, false
+	}
+	c, err := parseColor(style.Color)
+	return c, err == nil
+}
+
+// tableColumns turns the columns of the template into those of the detail
+// table.
+func (t *Template) tableColumns() []tableColumn {
+	columns := make([]tableColumn, len(t.Columns))
+	for i, col := range t.Columns {
+		tc := tableColumn{
+			header:   col.Label,
+			minWidth: col.MinWidth,
+			maxWidth: col.MaxWidth,
+			align:    col.Align,
+			wrap:     col.Wrap,
+			value:    fieldValues[col.Field],
+		}
+		if tc.header == "" {
+			tc.header = col.Field
+		}
+		if tc.align == "" {
+			tc.align = "L"
+		}
+		if col.Width > 0 {
+			tc.minWidth, tc.maxWidth = col.Width, col.Width
+		}
+		if tc.maxWidth == 0 {
+			tc.maxWidth = max(tc.minWidth, 100)
+		}
+
+		if col.Field == "class" {
+			tc.value = func(d models.DeviceData) string { return t.classLabel(d.Class, true) }
+			tc.color = func(d models.DeviceData) (rgb, bool) { return t.classColor(d.Class) }
+		}
+		columns[i] = tc
+	}
+	return columns
+}
+
+func parseColor(s string) (rgb, error) {
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) != 6 {
+		return rgb{}, fmt.Errorf("invalid color %q, expected #rrggbb", s)
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return rgb{}, fmt.Errorf("invalid color %q, expected #rrggbb", s)
+	}
+	return rgb{int(v >> 16), int(v >> 8 & 0xff), int(v & 0xff)}, nil
+}
@@ -0,0 +1,139 @@
+package generator
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeTemplate(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadTemplate(t *testing.T) {
+	defaults := DefaultTemplate()
+
+	tests := []struct {
+		name     string
+		file     string
+		content  string
+		sections []string
+		columns  []Column
+		check    func(t *testing.T, tmpl *Template)
+	}{
+		{
+			name:     "empty yaml keeps the defaults",
+			file:     "empty.yaml",
+			content:  "",
+			sections: defaults.Sections,
+			columns:  defaults.Columns,
+		},
+		{
+			name:     "yaml columns replace the default ones",
+			file:     "short.yaml",
+			content:  "columns:\n  - field: text\n    wrap: true\n",
+			sections: defaults.Sections,
+			columns:  []Column{{Field: "text", Wrap: true}},
+		},
+		{
+			name:     "json columns replace the default ones",
+			file:     "short.json",
+			content:  `{"columns": [{"field": "addr", "label": "Адрес"}, {"field": "level", "width": 16}]}`,
+			sections: defaults.Sections,
+			columns:  []Column{{Field: "addr", Label: "Адрес"}, {Field: "level", Width: 16}},
+		},
+		{
+			name:     "json sections replace the default ones",
+			file:     "details.json",
+			content:  `{"sections": ["details"]}`,
+			sections: []string{SectionDetails},
+			columns:  defaults.Columns,
+		},
+		{
+			name:     "labels and classes are merged",
+			file:     "labels.json",
+			content:  `{"labels": {"details": "Адреса"}, "classes": {"info": {"label": "Инфо", "color": "#0000ff"}}}`,
+			sections: defaults.Sections,
+			columns:  defaults.Columns,
+			check: func(t *testing.T, tmpl *Template) {
+				if tmpl.Labels.Details != "Адреса" || tmpl.Labels.Statistics != defaults.Labels.Statistics {
+					t.Errorf("labels = %+v", tmpl.Labels)
+				}
+				if tmpl.Classes["info"].Label != "Инфо" || tmpl.Classes["alarm"] != defaults.Classes["alarm"] {
+					t.Errorf("classes = %+v", tmpl.Classes)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := LoadTemplate(writeTemplate(t, tt.file, tt.content))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if want := strings.TrimSuffix(tt.file, filepath.Ext(tt.file)); tmpl.Name != want {
+				t.Errorf("name = %q, want %q", tmpl.Name, want)
+			}
+			if !reflect.DeepEqual(tmpl.Sections, tt.sections) {
+				t.Errorf("sections = %v, want %v", tmpl.Sections, tt.sections)
+			}
+			if !reflect.DeepEqual(tmpl.Columns, tt.columns) {
+				t.Errorf("columns = %+v, want %+v", tmpl.Columns, tt.columns)
+			}
+			if tt.check != nil {
+				tt.check(t, tmpl)
+			}
+		})
+	}
+
+	// Loading a template must not change the defaults of the next one.
+	if !reflect.DeepEqual(DefaultTemplate(), defaults) {
+		t.Errorf("default template changed")
+	}
+}
+
+func TestLoadTemplateErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		wantErr string
+	}{
+		{"unknown section", "a.yaml", "sections: [summary]\n", `unknown section "summary"`},
+		{"unknown field", "a.json", `{"columns": [{"field": "colour"}]}`, `unknown field "colour"`},
+		{"bad align", "a.yaml", "columns:\n  - field: text\n    align: X\n", "align must be L, C or R"},
+		{"negative width", "a.yaml", "columns:\n  - field: text\n    width: -1\n", "must not be negative"},
+		{"min above max", "a.json", `{"columns": [{"field": "text", "min_width": 50, "max_width": 40}]}`, "min_width is greater"},
+		{"bad color", "a.yaml", "classes:\n  alarm:\n    color: red\n", `invalid color "red"`},
+		{"invalid json", "a.json", `{"columns": `, "failed to parse"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadTemplate(writeTemplate(t, tt.file, tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExampleTemplates(t *testing.T) {
+	paths, err := filepath.Glob("../../templates/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		if _, err := LoadTemplate(path); err != nil {
+			t.Errorf("%s: %v", path, err)
+		}
+	}
+}
@@ -24,22 +24,131 @@ type DeviceData struct {
 	Bit       int                `bson:"bit" json:"bit"`
 	InvertBit int                `bson:"invert_bit" json:"invert_bit"`
 	FileName  string             `bson:"file_name" json:"file_name"`
+	FileHash  string             `bson:"file_hash,omitempty" json:"file_hash,omitempty"`
+	Source    string             `bson:"source,omitempty" json:"source,omitempty"`
+	Site      string             `bson:"site,omitempty" json:"site,omitempty"`
+	Metadata  map[string]string  `bson:"metadata,omitempty" json:"metadata,omitempty"`
 	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
 }
 
 type ProcessedFile struct {
-	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	FileName    string             `bson:"file_name" json:"file_name"`
-	FilePath    string             `bson:"file_path" json:"file_path"`
-	ProcessedAt time.Time          `bson:"processed_at" json:"processed_at"`
-	Status      string             `bson:"status" json:"status"`
-	ErrorMsg    string             `bson:"error_msg,omitempty" json:"error_msg,omitempty"`
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	FileName      string             `bson:"file_name" json:"file_name"`
+	FilePath      string             `bson:"file_path" json:"file_path"`
+	ParentArchive string             `bson:"parent_archive,omitempty" json:"parent_archive,omitempty"`
+	Source        string             `bson:"source,omitempty" json:"source,omitempty"`
+	Site          string             `bson:"site,omitempty" json:"site,omitempty"`
+	Metadata      map[string]string  `bson:"metadata,omitempty" json:"metadata,omitempty"`
+	ContentHash   string             `bson:"content_hash,omitempty" json:"content_hash,omitempty"`
+	ProcessedAt   time.Time          `bson:"processed_at" json:"processed_at"`
+	Status        string             `bson:"status" json:"status"`
+	ErrorMsg      string             `bson:"error_msg,omitempty" json:"error_msg,omitempty"`
+	Format        string             `bson:"format,omitempty" json:"format,omitempty"`
+	Encoding      string             `bson:"encoding,omitempty" json:"encoding,omitempty"`
+	TotalRows     int                `bson:"total_rows" json:"total_rows"`
+	InvalidRows   int                `bson:"invalid_rows" json:"invalid_rows"`
+
+	// ReprocessRequested is set when the file has been moved back to the
+	// input directory to be processed again.
+	ReprocessRequested bool `bson:"reprocess_requested,omitempty" json:"reprocess_requested,omitempty"`
+	// History holds the earlier processing attempts of the file, oldest
+	// first; the fields above describe the latest one.
+	History []ProcessingAttempt `bson:"history,omitempty" json:"history,omitempty"`
+}
+
+type ProcessingAttempt struct {
+	ProcessedAt time.Time `bson:"processed_at" json:"processed_at"`
+	Status      string    `bson:"status" json:"status"`
+	ErrorMsg    string    `bson:"error_msg,omitempty" json:"error_msg,omitempty"`
+	TotalRows   int       `bson:"total_rows" json:"total_rows"`
+	InvalidRows int       `bson:"invalid_rows" json:"invalid_rows"`
+}
+
+const (
+	JobQueued     = "queued"
+	JobRunning    = "running"
+	JobSucceeded  = "succeeded"
+	JobFailed     = "failed"
+	JobDeadLetter = "dead_letter"
+	// JobInterrupted marks a job that was cancelled by a shutdown; it is
+	// taken again on the next start.
+	JobInterrupted = "interrupted"
+)
+
+// Job is a unit of work in the persistent job queue. A running job is leased
+// to one worker until LeaseUntil; a lease that runs out, because its worker
+// crashed, makes the job available again. A job that keeps failing is parked
+// in the dead-letter state until an operator requeues it.
+type Job struct {
+	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	FilePath        string             `bson:"file_path" json:"file_path"`
+	FileName        string             `bson:"file_name" json:"file_name"`
+	ContentHash     string             `bson:"content_hash" json:"content_hash"`
+	Source          string             `bson:"source,omitempty" json:"source,omitempty"`
+	Status          string             `bson:"status" json:"status"`
+	Attempts        int                `bson:"attempts" json:"attempts"`
+	LeaseOwner      string             `bson:"lease_owner,omitempty" json:"lease_owner,omitempty"`
+	LeaseUntil      time.Time          `bson:"lease_until,omitempty" json:"lease_until,omitempty"`
+	NextAttemptAt   time.Time          `bson:"next_attempt_at" json:"next_attempt_at"`
+	LastError       string             `bson:"last_error,omitempty" json:"last_error,omitempty"`
+	CancelRequested bool               `bson:"cancel_requested,omitempty" json:"cancel_requested,omitempty"`
+	CreatedAt       time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt       time.Time          `bson:"updated_at" json:"updated_at"`
+
+	// WorkerID, StartedAt, FinishedAt and Stats describe the latest attempt.
+	WorkerID   string    `bson:"worker_id,omitempty" json:"worker_id,omitempty"`
+	StartedAt  time.Time `bson:"started_at,omitempty" json:"started_at,omitempty"`
+	FinishedAt time.Time `bson:"finished_at,omitempty" json:"finished_at,omitempty"`
+	Stats      JobStats  `bson:"stats" json:"stats"`
+}
+
+// JobStats describes the work done by a job. Reports are added by the report
+// queue as the reports requested by the job are generated, which may be after
+// the job has finished.
+type JobStats struct {
+	RowsRead    int64      `bson:"rows_read" json:"rows_read"`
+	RowsWritten int64      `bson:"rows_written" json:"rows_written"`
+	InvalidRows int64      `bson:"invalid_rows" json:"invalid_rows"`
+	Stages      []JobStage `bson:"stages,omitempty" json:"stages,omitempty"`
+	Reports     []string   `bson:"reports,omitempty" json:"reports,omitempty"`
+}
+
+// JobStage is the time spent in one stage of a job, such as ingest or report
+// generation.
+type JobStage struct {
+	Name       string `bson:"name" json:"name"`
+	DurationMs int64  `bson:"duration_ms" json:"duration_ms"`
+}
+
+// ReportRequest asks for the report of one device of a source to be
+// generated with a template, or the template of the source if it is empty.
+// Requests for the same device and template are merged into one until DueAt;
+// FileName and FileHash are those of the latest file that asked for it.
+type ReportRequest struct {
+	ID         primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
+	Source     string               `bson:"source,omitempty" json:"source,omitempty"`
+	UnitGUID   string               `bson:"unit_guid" json:"unit_guid"`
+	Template   string               `bson:"template" json:"template,omitempty"`
+	FileName   string               `bson:"file_name,omitempty" json:"file_name,omitempty"`
+	FileHash   string               `bson:"file_hash,omitempty" json:"file_hash,omitempty"`
+	JobIDs     []primitive.ObjectID `bson:"job_ids,omitempty" json:"job_ids,omitempty"`
+	Requests   int64                `bson:"requests" json:"requests"`
+	Attempts   int                  `bson:"attempts" json:"attempts"`
+	DueAt      time.Time            `bson:"due_at" json:"due_at"`
+	LeaseOwner string               `bson:"lease_owner,omitempty" json:"lease_owner,omitempty"`
+	LeaseUntil time.Time            `bson:"lease_until,omitempty" json:"lease_until,omitempty"`
+	LastError  string               `bson:"last_error,omitempty" json:"last_error,omitempty"`
+	CreatedAt  time.Time            `bson:"created_at" json:"created_at"`
 }
 
 type ProcessingError struct {
 	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
 	FileName  string             `bson:"file_name" json:"file_name"`
+	FileHash  string             `bson:"file_hash,omitempty" json:"file_hash,omitempty"`
+	Source    string             `bson:"source,omitempty" json:"source,omitempty"`
 	UnitGUID  string             `bson:"unit_guid,omitempty" json:"unit_guid,omitempty"`
+	RowNum    int                `bson:"row_num,omitempty" json:"row_num,omitempty"`
+	Column    string             `bson:"column,omitempty" json:"column,omitempty"`
 	ErrorMsg  string             `bson:"error_msg" json:"error_msg"`
 	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
 }
@@ -51,3 +160,11 @@ type PaginatedResponse struct {
 	Limit      int64        `json:"limit"`
 	TotalPages int64        `json:"total_pages"`
 }
+
+type PaginatedJobsResponse struct {
+	Data       []Job `json:"data"`
+	Total      int64 `json:"total"`
+	Page       int64 `json:"page"`
+	Limit      int64 `json:"limit"`
+	TotalPages int64 `json:"total_pages"`
+}
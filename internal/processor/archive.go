@@ -0,0 +1,178 @@
+package processor
+
+import (
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/tsv-processor/internal/models"
+)
+
+const (
+	gzipExt = ".gz"
+	zipExt  = ".zip"
+)
+
+func isZipArchive(name string) bool {
+	return strings.HasSuffix(strings.ToLower(name), zipExt)
+}
+
+func isGzip(name string) bool {
+	return strings.HasSuffix(strings.ToLower(name), gzipExt)
+}
+
+// decompress unwraps gzip-compressed input and returns the name of the file
+// inside it, which is what the input format is detected from.
+func decompress(r io.Reader, name string) (io.Reader, string, error) {
+	if !isGzip(name) {
+		return r, name, nil
+	}
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to open gzip stream: %w", err)
+	}
+	return gz, name[:len(name)-len(gzipExt)], nil
+}
+
+func (wp *WorkerPool) acceptsInput(src *source, name string) bool {
+	if wp.readiness.auxiliary(name) {
+		return false
+	}
+	if isZipArchive(name) {
+		return true
+	}
+	if isGzip(name) {
+		name = name[:len(name)-len(gzipExt)]
+	}
+	return src.accepts(name)
+}
+
+// processArchive ingests every supported member of a zip archive as its own
+// logical file named "<archive>/<member>". The archive is archived only when
+// all of its members succeed.
+func (wp *WorkerPool) processArchive(ctx context.Context, job Job) (fileOutcome, error) {
+	archiveFile := &models.ProcessedFile{
+		ID:          primitive.NewObjectID(),
+		FileName:    job.FileName,
+		FilePath:    job.FilePath,
+		Source:      job.Source.Name,
+		Site:        job.Source.Site,
+		Metadata:    job.Source.metadata(job.FileName),
+		ContentHash: job.ContentHash,
+		ProcessedAt: time.Now(),
+		Status:      "success",
+	}
+
+	zr, err := zip.OpenReader(job.FilePath)
+	if err != nil {
+		outcome, err := wp.failInput(ctx, archiveFile, fmt.Errorf("failed to open archive: %w", err))
+		wp.finishFile(job, outcome)
+		return outcome, err
+	}
+
+	summary := wp.processArchiveMembers(ctx, job, zr)
+	zr.Close()
+
+	if summary.members == 0 && summary.err == nil {
+		outcome, err := wp.failInput(ctx, archiveFile, errors.New("archive contains no supported files"))
+		wp.finishFile(job, outcome)
+		return outcome, err
+	}
+
+	log.Printf("Processed archive %s: %d members, %d failed", job.FileName, summary.members, summary.failed)
+
+	// Members that succeeded are skipped as duplicates when the archive is
+	// retried, so it is only recorded once the outcome is final.
+	if summary.outcome != outcomeRetry {
+		if summary.failed > 0 {
+			archiveFile.Status = "error"
+			archiveFile.ErrorMsg = fmt.Sprintf("%d of %d archive members failed", summary.failed, summary.members)
+		}
+
+		// The record is kept even if the job has just been cancelled.
+		ctx := context.WithoutCancel(ctx)
+
+		existing, err := wp.db.GetProcessedFileByHash(ctx, job.Source.Name, job.ContentHash)
+		if err != nil {
+			log.Printf("Error looking up earlier record of archive %s: %v", job.FileName, err)
+		}
+		if existing != nil && (existing.Status == "error" || existing.ReprocessRequested) {
+			supersede(archiveFile, existing)
+		}
+
+		if err := wp.db.SaveProcessedFile(ctx, archiveFile); err != nil {
+			log.Printf("Error saving processed file record: %v", err)
+		}
+	}
+
+	wp.finishFile(job, summary.outcome)
+	return summary.outcome, summary.err
+}
+
+type archiveSummary struct {
+	outcome fileOutcome
+	err     error
+	members int
+	failed  int
+}
+
+// processArchiveMembers returns the highest outcome among the members, with
+// the error that caused it, and the number of members processed and failed.
+func (wp *WorkerPool) processArchiveMembers(ctx context.Context, job Job, zr *zip.ReadCloser) archiveSummary {
+	summary := archiveSummary{outcome: outcomeSuccess}
+
+	for _, member := range zr.File {
+		// The remaining members were not ingested, so the archive must not
+		// be recorded as done: a cancel through the API rejects it and a
+		// shutdown leaves it to be retried.
+		if errors.Is(context.Cause(ctx), errJobCancelled) {
+			summary.outcome, summary.err = outcomeRejected, errJobCancelled
+			break
+		}
+		if ctx.Err() != nil {
+			summary.outcome, summary.err = outcomeRetry, ctx.Err()
+			break
+		}
+
+		if member.FileInfo().IsDir() || isZipArchive(member.Name) || !wp.acceptsInput(job.Source, path.Base(member.Name)) {
+			log.Printf("Skipping unsupported archive member: %s/%s", job.FileName, member.Name)
+			continue
+		}
+
+		summary.members++
+		name := job.FileName + "/" + member.Name
+
+		var outcome fileOutcome
+		hash, err := hashInput(member.Open)
+		if err != nil {
+			log.Printf("Error reading archive member %s: %v", name, err)
+			outcome, err = outcomeRejected, fmt.Errorf("failed to read archive member %s: %w", name, err)
+		} else {
+			outcome, err = wp.processInput(ctx, job, inputFile{
+				Name:   name,
+				Parent: job.FileName,
+				Hash:   hash,
+				Open:   member.Open,
+			})
+		}
+
+		if outcome != outcomeSuccess {
+			summary.failed++
+		}
+		if outcome > summary.outcome {
+			summary.outcome, summary.err = outcome, err
+		}
+	}
+
+	return summary
+}
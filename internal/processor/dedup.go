@@ -0,0 +1,145 @@
+package processor
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tsv-processor/internal/config"
+	"github.com/tsv-processor/internal/models"
+)
+
+func hashFile(filePath string) (string, error) {
+	return hashInput(func() (io.ReadCloser, error) {
+		return os.Open(filePath)
+	})
+}
+
+type cachedHash struct {
+	size    int64
+	modTime time.Time
+	hash    string
+}
+
+// hashCache remembers the hashes of the files in the input directories, so
+// that a scan only reads the files that are new or whose size or
+// modification time changed since the last one.
+type hashCache struct {
+	mu     sync.Mutex
+	hashes map[string]cachedHash
+}
+
+func newHashCache() *hashCache {
+	return &hashCache{hashes: make(map[string]cachedHash)}
+}
+
+func (c *hashCache) hash(filePath string, info os.FileInfo) (string, error) {
+	c.mu.Lock()
+	cached, ok := c.hashes[filePath]
+	c.mu.Unlock()
+	if ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		return cached.hash, nil
+	}
+
+	hash, err := hashFile(filePath)
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	c.hashes[filePath] = cachedHash{size: info.Size(), modTime: info.ModTime(), hash: hash}
+	c.mu.Unlock()
+	return hash, nil
+}
+
+// forget drops the hashes of files under root that are no longer in the
+// input directory.
+func (c *hashCache) forget(root string, present map[string]bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	root = filepath.Clean(root) + string(filepath.Separator)
+	for filePath := range c.hashes {
+		if strings.HasPrefix(filePath, root) && !present[filePath] {
+			delete(c.hashes, filePath)
+		}
+	}
+}
+
+func hashInput(open func() (io.ReadCloser, error)) (string, error) {
+	r, err := open()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// errDifferentVersion rejects a file whose name was already processed with
+// other content under the reject policy.
+var errDifferentVersion = errors.New("a different version of the file was already processed")
+
+// checkDuplicate applies content deduplication and the duplicate name policy
+// before an input is ingested. It reports whether the input can be skipped
+// because its content is already stored.
+func (wp *WorkerPool) checkDuplicate(ctx context.Context, in inputFile, processedFile *models.ProcessedFile) (bool, error) {
+	existing, err := wp.db.GetProcessedFileByHash(ctx, processedFile.Source, in.Hash)
+	if err != nil {
+		return false, fmt.Errorf("%w: %w", errDatabase, err)
+	}
+
+	if existing != nil && existing.Status != "error" && !existing.ReprocessRequested {
+		log.Printf("Skipping file %s: same content as already processed file %s", in.Name, existing.FileName)
+		return true, nil
+	}
+
+	// A failed attempt with the same content, or one to be reprocessed, is
+	// superseded by this one.
+	if existing != nil {
+		supersede(processedFile, existing)
+	}
+
+	if wp.dupPolicy != config.DuplicateNameReject {
+		return false, nil
+	}
+
+	previous, err := wp.db.GetProcessedFilesByName(ctx, processedFile.Source, in.Name)
+	if err != nil {
+		return false, fmt.Errorf("%w: %w", errDatabase, err)
+	}
+
+	for _, p := range previous {
+		if p.ContentHash != in.Hash && p.Status != "error" {
+			return false, fmt.Errorf("%w at %s", errDifferentVersion, p.ProcessedAt.Format("2006-01-02 15:04:05"))
+		}
+	}
+
+	return false, nil
+}
+
+// supersede makes processedFile replace an earlier record of the same content
+// when it is saved, keeping the earlier attempt in the history.
+func supersede(processedFile, existing *models.ProcessedFile) {
+	processedFile.ID = existing.ID
+	processedFile.History = append(existing.History, models.ProcessingAttempt{
+		ProcessedAt: existing.ProcessedAt,
+		Status:      existing.Status,
+		ErrorMsg:    existing.ErrorMsg,
+		TotalRows:   existing.TotalRows,
+		InvalidRows: existing.InvalidRows,
+	})
+}
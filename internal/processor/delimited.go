@@ -0,0 +1,155 @@
+package processor
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/tsv-processor/internal/models"
+)
+
+type TSVParser struct{}
+
+func NewTSVParser() *TSVParser {
+	return &TSVParser{}
+}
+
+func (p *TSVParser) NewReader(r io.Reader, fileName string, schema *Schema) (RecordReader, error) {
+	return newDelimitedReader(r, '\t', fileName, schema)
+}
+
+// CSVParser reads comma or semicolon separated exports. The delimiter is taken
+// from the first line, since Excel with a Russian locale writes semicolons.
+type CSVParser struct{}
+
+func NewCSVParser() *CSVParser {
+	return &CSVParser{}
+}
+
+func (p *CSVParser) NewReader(r io.Reader, fileName string, schema *Schema) (RecordReader, error) {
+	buffered := bufio.NewReader(r)
+	firstLine, err := buffered.Peek(4096)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	if i := bytes.IndexByte(firstLine, '\n'); i >= 0 {
+		firstLine = firstLine[:i]
+	}
+
+	return newDelimitedReader(buffered, detectDelimiter(firstLine), fileName, schema)
+}
+
+func detectDelimiter(line []byte) rune {
+	comma := ','
+	best := bytes.Count(line, []byte{','})
+	for _, candidate := range []rune{';', '\t'} {
+		if n := bytes.Count(line, []byte(string(candidate))); n > best {
+			comma, best = candidate, n
+		}
+	}
+	return comma
+}
+
+type delimitedReader struct {
+	reader   *csv.Reader
+	columns  columnIndex
+	row      []string
+	fileName string
+	schema   *Schema
+}
+
+// newDelimitedReader locates the header row among the first two lines: the
+// TSV template puts human-readable descriptions above the column names, while
+// plain exports start with the column names directly.
+func newDelimitedReader(r io.Reader, comma rune, fileName string, schema *Schema) (*delimitedReader, error) {
+	reader := csv.NewReader(r)
+	reader.Comma = comma
+	reader.FieldsPerRecord = -1
+	// TSV has no quoting: a quote in the text of a message is just a
+	// character.
+	reader.LazyQuotes = comma == '\t'
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+
+	columns, err := newColumnIndex(header)
+	if err != nil {
+		header, err = reader.Read()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read second header: %w", err)
+		}
+
+		columns, err = newColumnIndex(header)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &delimitedReader{
+		reader:   reader,
+		columns:  columns,
+		fileName: fileName,
+		schema:   schema,
+	}, nil
+}
+
+func (r *delimitedReader) Next() (*models.DeviceData, error) {
+	row, err := r.reader.Read()
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return nil, RowError{Row: parseErr.Line, Reason: parseErr.Err.Error()}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	r.row = row
+	rowNum, _ := r.reader.FieldPos(0)
+
+	return buildRecord(r.get, rowNum, r.fileName, r.schema)
+}
+
+func (r *delimitedReader) get(name string) string {
+	i, ok := r.columns[name]
+	if !ok || i >= len(r.row) {
+		return ""
+	}
+	return strings.TrimSpace(r.row[i])
+}
+
+// columnIndex maps column names from the header row to field positions, so
+// that empty or reordered columns do not shift the values of the others.
+type columnIndex map[string]int
+
+func newColumnIndex(header []string) (columnIndex, error) {
+	columns := make(columnIndex, len(header))
+	for i, name := range header {
+		name = normalizeColumn(name)
+		if name == "" {
+			continue
+		}
+		if _, exists := columns[name]; exists {
+			return nil, fmt.Errorf("duplicate column %q in header", name)
+		}
+		columns[name] = i
+	}
+
+	for _, name := range requiredColumns {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("missing required column %q in header", name)
+		}
+	}
+
+	return columns, nil
+}
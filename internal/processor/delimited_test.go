@@ -0,0 +1,119 @@
+package processor
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/tsv-processor/internal/config"
+)
+
+func TestNewColumnIndex(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  []string
+		want    columnIndex
+		wantErr string
+	}{
+		{
+			name:   "template columns",
+			header: []string{"n", "unit_guid", "msg_id", "text"},
+			want:   columnIndex{"n": 0, "unit_guid": 1, "msg_id": 2, "text": 3},
+		},
+		{
+			name:   "case, spaces and aliases",
+			header: []string{" Row_Num ", "UNIT_GUID", "msg_id", "Inventory"},
+			want:   columnIndex{"n": 0, "unit_guid": 1, "msg_id": 2, "invid": 3},
+		},
+		{
+			name:   "empty columns are skipped",
+			header: []string{"unit_guid", "", "msg_id", " "},
+			want:   columnIndex{"unit_guid": 0, "msg_id": 2},
+		},
+		{
+			name:    "duplicate through alias",
+			header:  []string{"n", "row_num", "unit_guid", "msg_id"},
+			wantErr: `duplicate column "n"`,
+		},
+		{
+			name:    "missing required column",
+			header:  []string{"n", "unit_guid", "text"},
+			wantErr: `missing required column "msg_id"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newColumnIndex(tt.header)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for name, i := range tt.want {
+				if got[name] != i {
+					t.Errorf("column %q at %d, want %d", name, got[name], i)
+				}
+			}
+		})
+	}
+}
+
+func TestDelimitedReaderQuotes(t *testing.T) {
+	schema, err := NewSchema(config.ValidationConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := "unit_guid\tmsg_id\ttext\n" +
+		"g1\tm1\tvalve \"A\" open\n" +
+		"g1\tm2\t12\" pipe\n"
+	reader, err := newDelimitedReader(strings.NewReader(input), '\t', "test.tsv", schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var texts []string
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		texts = append(texts, record.Text)
+	}
+
+	want := []string{`valve "A" open`, `12" pipe`}
+	if strings.Join(texts, "|") != strings.Join(want, "|") {
+		t.Errorf("texts = %q, want %q", texts, want)
+	}
+}
+func TestDetectDelimiter(t *testing.T) {
+	tests := []struct {
+		line string
+		want rune
+	}{
+		{"n,unit_guid,msg_id", ','},
+		{"n;unit_guid;msg_id", ';'},
+		{"n\tunit_guid\tmsg_id", '\t'},
+		{"n\tunit_guid\ttext, with a comma", '\t'},
+		{"n;unit_guid;text, with a comma", ';'},
+		{"unit_guid", ','},
+		{"", ','},
+	}
+
+	for _, tt := range tests {
+		if got := detectDelimiter([]byte(tt.line)); got != tt.want {
+			t.Errorf("detectDelimiter(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
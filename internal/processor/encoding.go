@@ -0,0 +1,134 @@
+package processor
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+const (
+	EncodingUTF8        = "utf-8"
+	EncodingUTF16LE     = "utf-16le"
+	EncodingUTF16BE     = "utf-16be"
+	EncodingWindows1251 = "windows-1251"
+)
+
+// detectSize is how much of a file is inspected when guessing its encoding.
+const detectSize = 64 * 1024
+
+// decodeInput converts the input to UTF-8 and strips a byte order mark. An
+// empty override means the encoding is detected from the content; the name of
+// the encoding that was used is returned alongside the reader.
+func decodeInput(r *bufio.Reader, override string) (io.Reader, string, error) {
+	name := override
+	if strings.TrimSpace(name) == "" {
+		head, err := r.Peek(detectSize)
+		if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+			return nil, "", fmt.Errorf("failed to read file: %w", err)
+		}
+		name = detectEncoding(head)
+	}
+
+	enc, err := lookupEncoding(name)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// BOMOverride lets a byte order mark win over the configured encoding and
+	// removes it from the output.
+	decoder := unicode.BOMOverride(enc.NewDecoder())
+	return transform.NewReader(r, decoder), name, nil
+}
+
+func lookupEncoding(name string) (encoding.Encoding, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case EncodingUTF8:
+		return unicode.UTF8, nil
+	case EncodingUTF16LE:
+		return unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM), nil
+	case EncodingUTF16BE:
+		return unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM), nil
+	case EncodingWindows1251, "cp1251":
+		return charmap.Windows1251, nil
+	}
+
+	enc, err := htmlindex.Get(name)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported encoding %q", name)
+	}
+	return enc, nil
+}
+
+func detectEncoding(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, []byte{0xEF, 0xBB, 0xBF}):
+		return EncodingUTF8
+	case bytes.HasPrefix(head, []byte{0xFF, 0xFE}):
+		return EncodingUTF16LE
+	case bytes.HasPrefix(head, []byte{0xFE, 0xFF}):
+		return EncodingUTF16BE
+	}
+
+	if enc := detectUTF16(head); enc != "" {
+		return enc
+	}
+
+	if utf8.Valid(trimPartialRune(head)) {
+		return EncodingUTF8
+	}
+
+	// Text that is not UTF-8 comes from Windows tools with a Cyrillic locale.
+	return EncodingWindows1251
+}
+
+// detectUTF16 recognises UTF-16 without a byte order mark by the zero bytes
+// that ASCII characters leave in every other position.
+func detectUTF16(head []byte) string {
+	if len(head) < 4 {
+		return ""
+	}
+
+	var evenZeros, oddZeros int
+	for i, b := range head {
+		if b != 0 {
+			continue
+		}
+		if i%2 == 0 {
+			evenZeros++
+		} else {
+			oddZeros++
+		}
+	}
+
+	half := len(head) / 2
+	switch {
+	case oddZeros > half/4 && evenZeros < half/10:
+		return EncodingUTF16LE
+	case evenZeros > half/4 && oddZeros < half/10:
+		return EncodingUTF16BE
+	}
+	return ""
+}
+
+// trimPartialRune drops a multi-byte sequence cut off by the end of the
+// buffer, so that it does not make valid UTF-8 look invalid.
+func trimPartialRune(b []byte) []byte {
+	for i := 1; i <= utf8.UTFMax && i <= len(b); i++ {
+		if utf8.RuneStart(b[len(b)-i]) {
+			if !utf8.FullRune(b[len(b)-i:]) {
+				return b[:len(b)-i]
+			}
+			break
+		}
+	}
+	return b
+}
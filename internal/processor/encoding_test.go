@@ -0,0 +1,75 @@
+package processor
+
+import (
+	"testing"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+)
+
+func encodeString(t *testing.T, enc encoding.Encoding, s string) []byte {
+	t.Helper()
+	b, err := enc.NewEncoder().Bytes([]byte(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestDetectEncoding(t *testing.T) {
+	const text = "n\tunit_guid\tmsg_id\ttext\n1\tg1\tm1\tДатчик давления\n"
+
+	utf16LE := unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM)
+	utf16BE := unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM)
+	utf8 := []byte(text)
+
+	tests := []struct {
+		name string
+		head []byte
+		want string
+	}{
+		{"utf-8", utf8, EncodingUTF8},
+		{"utf-8 with bom", append([]byte{0xEF, 0xBB, 0xBF}, utf8...), EncodingUTF8},
+		{"utf-8 cut inside a rune", utf8[:len(utf8)-len("я\n")-1], EncodingUTF8},
+		{"utf-16le with bom", append([]byte{0xFF, 0xFE}, encodeString(t, utf16LE, text)...), EncodingUTF16LE},
+		{"utf-16be with bom", append([]byte{0xFE, 0xFF}, encodeString(t, utf16BE, text)...), EncodingUTF16BE},
+		{"utf-16le without bom", encodeString(t, utf16LE, text), EncodingUTF16LE},
+		{"utf-16be without bom", encodeString(t, utf16BE, text), EncodingUTF16BE},
+		{"windows-1251", encodeString(t, charmap.Windows1251, text), EncodingWindows1251},
+		{"empty", nil, EncodingUTF8},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := detectEncoding(tt.head); got != tt.want {
+				t.Errorf("detectEncoding() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectUTF16(t *testing.T) {
+	utf16LE := unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM)
+	utf16BE := unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM)
+
+	tests := []struct {
+		name string
+		head []byte
+		want string
+	}{
+		{"too short", []byte{'a', 0}, ""},
+		{"ascii little endian", encodeString(t, utf16LE, "unit_guid\tmsg_id\ttext"), EncodingUTF16LE},
+		{"ascii big endian", encodeString(t, utf16BE, "unit_guid\tmsg_id\ttext"), EncodingUTF16BE},
+		{"no zero bytes", []byte("unit_guid\tmsg_id"), ""},
+		{"zeros on both sides", []byte{0, 0, 'a', 0, 0, 'b', 0, 0}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := detectUTF16(tt.head); got != tt.want {
+				t.Errorf("detectUTF16() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/tsv-processor/internal/models"
+)
+
+// scannerLease is the lease held by the instance elected to scan the input
+// directories.
+const scannerLease = "scanner"
+
+// fileClaim names the lease a worker holds on an input file while a job
+// processes it. The content hash is left out, so that two versions of a file
+// are not processed at the same time either.
+func fileClaim(job Job) string {
+	return "file:" + job.Source.Name + ":" + job.FileName
+}
+
+// keepLeadership campaigns for the scanner lease and renews it while this
+// instance holds it. Only the leader scans; every instance runs workers.
+func (wp *WorkerPool) keepLeadership(ctx context.Context) {
+	ttl := wp.cfg.GetLeaderLease()
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		acquired, err := wp.db.AcquireLease(ctx, scannerLease, wp.instanceID, ttl)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Error renewing scanner lease: %v", err)
+		}
+
+		if acquired != wp.leader.Load() {
+			if acquired {
+				log.Printf("Instance %s is now the scanner leader", wp.instanceID)
+			} else {
+				log.Printf("Instance %s is no longer the scanner leader", wp.instanceID)
+			}
+			wp.leader.Store(acquired)
+		}
+
+		select {
+		case <-ctx.Done():
+			if wp.leader.Load() {
+				releaseCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), settleTimeout)
+				if err := wp.db.ReleaseLease(releaseCtx, scannerLease, wp.instanceID); err != nil {
+					log.Printf("Error releasing scanner lease: %v", err)
+				}
+				cancel()
+				wp.leader.Store(false)
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// settleMissingFile ends a job whose file has left the input directory, which
+// happens when another instance processed the same file first.
+func (wp *WorkerPool) settleMissingFile(ctx context.Context, owner string, job Job) {
+	processed, err := wp.db.GetProcessedFilesByName(ctx, job.Source.Name, job.FileName)
+	if err != nil {
+		wp.settleJob(ctx, owner, job, outcomeRetry, err)
+		return
+	}
+
+	for _, p := range processed {
+		if p.ContentHash == job.ContentHash {
+			log.Printf("File %s was already processed by another worker", job.FileName)
+			if err := wp.db.AckJob(ctx, job.ID, owner, models.JobStats{}); err != nil {
+				log.Printf("Error acknowledging job for %s: %v", job.FileName, err)
+			}
+			return
+		}
+	}
+
+	wp.settleJob(ctx, owner, job, outcomeRejected, fmt.Errorf("input file %s no longer exists", job.FilePath))
+}
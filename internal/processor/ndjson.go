@@ -0,0 +1,78 @@
+package processor
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/tsv-processor/internal/models"
+)
+
+const maxJSONLineSize = 1024 * 1024
+
+// NDJSONParser reads one JSON object per line, keyed by the same column names
+// as the TSV template.
+type NDJSONParser struct{}
+
+func NewNDJSONParser() *NDJSONParser {
+	return &NDJSONParser{}
+}
+
+func (p *NDJSONParser) NewReader(r io.Reader, fileName string, schema *Schema) (RecordReader, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxJSONLineSize)
+
+	return &ndjsonReader{
+		scanner:  scanner,
+		fileName: fileName,
+		schema:   schema,
+	}, nil
+}
+
+type ndjsonReader struct {
+	scanner  *bufio.Scanner
+	fields   map[string]string
+	line     int
+	fileName string
+	schema   *Schema
+}
+
+func (r *ndjsonReader) Next() (*models.DeviceData, error) {
+	for r.scanner.Scan() {
+		r.line++
+
+		line := bytes.TrimSpace(r.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var object map[string]interface{}
+		decoder := json.NewDecoder(bytes.NewReader(line))
+		decoder.UseNumber()
+		if err := decoder.Decode(&object); err != nil {
+			return nil, RowError{Row: r.line, Reason: fmt.Sprintf("invalid JSON: %v", err)}
+		}
+
+		r.fields = make(map[string]string, len(object))
+		for key, value := range object {
+			if value == nil {
+				continue
+			}
+			r.fields[normalizeColumn(key)] = strings.TrimSpace(fmt.Sprint(value))
+		}
+
+		return buildRecord(r.get, r.line, r.fileName, r.schema)
+	}
+
+	if err := r.scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	return nil, io.EOF
+}
+
+func (r *ndjsonReader) get(name string) string {
+	return r.fields[name]
+}
@@ -1,10 +1,8 @@
 package processor
 
 import (
-	"encoding/csv"
 	"fmt"
 	"io"
-	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -13,82 +11,98 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-type TSVParser struct{}
+const (
+	colRowNum    = "n"
+	colMQTT      = "mqtt"
+	colInventory = "invid"
+	colUnitGUID  = "unit_guid"
+	colMsgID     = "msg_id"
+	colText      = "text"
+	colContext   = "context"
+	colClass     = "class"
+	colLevel     = "level"
+	colArea      = "area"
+	colAddr      = "addr"
+	colBlock     = "block"
+	colType      = "type"
+	colBit       = "bit"
+	colInvertBit = "invert_bit"
+)
+
+var requiredColumns = []string{colUnitGUID, colMsgID}
 
-func NewTSVParser() *TSVParser {
-	return &TSVParser{}
+// columnAliases lets inputs use the DeviceData field names instead of the
+// template column names.
+var columnAliases = map[string]string{
+	"row_num":   colRowNum,
+	"inventory": colInventory,
 }
 
-func (p *TSVParser) Parse(filePath, fileName string) ([]*models.DeviceData, error) {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open file: %w", err)
-	}
-	defer file.Close()
+// Parser turns the contents of one input file into DeviceData records.
+type Parser interface {
+	NewReader(r io.Reader, fileName string, schema *Schema) (RecordReader, error)
+}
 
-	reader := csv.NewReader(file)
-	reader.Comma = '\t'
-	reader.FieldsPerRecord = -1
-	reader.TrimLeadingSpace = true
+// RecordReader returns the records of a file one at a time. A row that cannot
+// be used is reported as a RowError and reading may continue; io.EOF marks
+// the end of the input.
+type RecordReader interface {
+	Next() (*models.DeviceData, error)
+}
 
-	_, err = reader.Read()
-	if err != nil {
-		return nil, fmt.Errorf("failed to skip first header: %w", err)
-	}
+type RowError struct {
+	Row    int
+	Column string
+	Reason string
+}
 
-	_, err = reader.Read()
-	if err != nil {
-		return nil, fmt.Errorf("failed to skip second header: %w", err)
+func (e RowError) Error() string {
+	if e.Column == "" {
+		return fmt.Sprintf("row %d: %s", e.Row, e.Reason)
 	}
+	return fmt.Sprintf("row %d, column %s: %s", e.Row, e.Column, e.Reason)
+}
 
-	var records []*models.DeviceData
-	rowNum := 3
-
-	for {
-		row, err := reader.Read()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return nil, fmt.Errorf("error reading row %d: %w", rowNum, err)
-		}
-
-		var cleanRow []string
-		for _, field := range row {
-			trimmed := strings.TrimSpace(field)
-			if trimmed != "" {
-				cleanRow = append(cleanRow, trimmed)
-			}
-		}
-		row = cleanRow
-
-		record := &models.DeviceData{
-			ID:        primitive.NewObjectID(),
-			FileName:  fileName,
-			CreatedAt: time.Now(),
-
-			RowNum:    mustAtoi(row[0]),
-			Inventory: row[1],
-			UnitGUID:  row[2],
-			MsgID:     row[3],
-			Text:      row[4],
-			Class:     row[5],
-			Level:     mustAtoi(row[6]),
-			Area:      row[7],
-			Addr:      row[8],
-		}
+func normalizeColumn(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if alias, ok := columnAliases[name]; ok {
+		return alias
+	}
+	return name
+}
 
-		if record.UnitGUID == "" || record.MsgID == "" {
-			return nil, fmt.Errorf("row %d: missing required fields", rowNum)
-		}
+// fieldGetter returns the trimmed value of a named column of the current row.
+type fieldGetter func(name string) string
 
-		records = append(records, record)
-		rowNum++
+func buildRecord(get fieldGetter, rowNum int, fileName string, schema *Schema) (*models.DeviceData, error) {
+	if err := schema.Validate(get, rowNum); err != nil {
+		return nil, err
 	}
 
-	return records, nil
+	return &models.DeviceData{
+		ID:        primitive.NewObjectID(),
+		FileName:  fileName,
+		CreatedAt: time.Now(),
+
+		RowNum:    mustAtoi(get(colRowNum)),
+		MQTT:      get(colMQTT),
+		Inventory: get(colInventory),
+		UnitGUID:  get(colUnitGUID),
+		MsgID:     get(colMsgID),
+		Text:      get(colText),
+		Context:   get(colContext),
+		Class:     get(colClass),
+		Level:     mustAtoi(get(colLevel)),
+		Area:      get(colArea),
+		Addr:      get(colAddr),
+		Block:     get(colBlock),
+		Type:      get(colType),
+		Bit:       mustAtoi(get(colBit)),
+		InvertBit: mustAtoi(get(colInvertBit)),
+	}, nil
 }
 
+// mustAtoi converts values that the schema has already checked to be integers.
 func mustAtoi(s string) int {
 	i, _ := strconv.Atoi(s)
 	return i
@@ -0,0 +1,192 @@
+package processor
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/tsv-processor/internal/models"
+)
+
+// pendingBatches bounds how far parsing may run ahead of the database writes.
+const pendingBatches = 2
+
+const sniffSize = 4096
+
+var errDatabase = errors.New("DB error")
+
+var errTooManyInvalidRows = errors.New("too many invalid rows")
+
+// maxRowErrors is how many row errors are kept and stored per file. A file
+// with a broken column may have an error on every row; the rest are only
+// counted.
+const maxRowErrors = 1000
+
+type ingestResult struct {
+	Format      string
+	Encoding    string
+	TotalRows   int
+	InvalidRows int
+	RowErrors   []RowError
+	UnitGUIDs   []string
+	RowsStored  int
+}
+
+func (r *ingestResult) ErrorRate() float64 {
+	if r.TotalRows == 0 {
+		return 0
+	}
+	return float64(r.InvalidRows) / float64(r.TotalRows)
+}
+
+type readSummary struct {
+	totalRows   int
+	invalidRows int
+	rowErrors   []RowError
+	err         error
+}
+
+func (wp *WorkerPool) ingest(ctx context.Context, job Job, in inputFile) (*ingestResult, error) {
+	name := in.Name
+	file, err := in.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	input, innerName, err := decompress(file, name)
+	if err != nil {
+		return nil, err
+	}
+
+	decoded, enc, err := decodeInput(bufio.NewReaderSize(input, detectSize), job.Source.Encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	buffered := bufio.NewReader(decoded)
+	head, err := buffered.Peek(sniffSize)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	format, parser, err := job.Source.detect(innerName, head)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := parser.NewReader(buffered, name, wp.schema)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	batches := make(chan []*models.DeviceData, pendingBatches)
+	summary := make(chan readSummary, 1)
+
+	go func() {
+		defer close(batches)
+		summary <- wp.readBatches(ctx, reader, job.Progress, batches)
+	}()
+
+	result := &ingestResult{Format: format, Encoding: enc}
+	metadata := job.Source.metadata(job.FileName)
+	guidSet := make(map[string]bool)
+
+	for batch := range batches {
+		for _, record := range batch {
+			record.FileHash = in.Hash
+			record.Source = job.Source.Name
+			record.Site = job.Source.Site
+			record.Metadata = metadata
+		}
+
+		if err := wp.db.SaveDeviceData(ctx, batch); err != nil {
+			cancel()
+			for range batches {
+			}
+			return result, fmt.Errorf("%w: %w", errDatabase, err)
+		}
+
+		result.RowsStored += len(batch)
+		for _, record := range batch {
+			guidSet[record.UnitGUID] = true
+		}
+
+		written := job.Progress.RowsWritten.Add(int64(len(batch)))
+		log.Printf("File %s: %d rows read, %d rows written", name, job.Progress.RowsRead.Load(), written)
+	}
+
+	s := <-summary
+	result.TotalRows = s.totalRows
+	result.InvalidRows = s.invalidRows
+	result.RowErrors = s.rowErrors
+	if s.err != nil {
+		return result, s.err
+	}
+
+	result.UnitGUIDs = make([]string, 0, len(guidSet))
+	for guid := range guidSet {
+		result.UnitGUIDs = append(result.UnitGUIDs, guid)
+	}
+
+	return result, nil
+}
+
+func (wp *WorkerPool) readBatches(ctx context.Context, reader RecordReader, progress *JobProgress, batches chan<- []*models.DeviceData) readSummary {
+	var s readSummary
+	batchSize := wp.cfg.GetBatchSize()
+	batch := make([]*models.DeviceData, 0, batchSize)
+
+	send := func() bool {
+		select {
+		case batches <- batch:
+			batch = make([]*models.DeviceData, 0, batchSize)
+			return true
+		case <-ctx.Done():
+			s.err = ctx.Err()
+			return false
+		}
+	}
+
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+
+		var rowErr RowError
+		if errors.As(err, &rowErr) {
+			s.totalRows++
+			s.invalidRows++
+			if len(s.rowErrors) < maxRowErrors {
+				s.rowErrors = append(s.rowErrors, rowErr)
+			}
+			progress.RowsRead.Add(1)
+			continue
+		}
+		if err != nil {
+			s.err = err
+			return s
+		}
+
+		s.totalRows++
+		progress.RowsRead.Add(1)
+		batch = append(batch, record)
+
+		if len(batch) >= batchSize && !send() {
+			return s
+		}
+	}
+
+	if len(batch) > 0 {
+		send()
+	}
+
+	return s
+}
@@ -0,0 +1,40 @@
+package processor
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/tsv-processor/internal/config"
+	"github.com/tsv-processor/internal/models"
+)
+
+// invalidRows returns a row error for each of its rows.
+type invalidRows struct {
+	rows, read int
+}
+
+func (r *invalidRows) Next() (*models.DeviceData, error) {
+	if r.read == r.rows {
+		return nil, io.EOF
+	}
+	r.read++
+	return nil, RowError{Row: r.read, Column: colLevel, Reason: "not an integer"}
+}
+
+func TestReadBatchesKeepsFirstRowErrors(t *testing.T) {
+	wp := &WorkerPool{cfg: &config.WatcherConfig{}}
+	progress := &JobProgress{}
+	batches := make(chan []*models.DeviceData, 1)
+
+	s := wp.readBatches(context.Background(), &invalidRows{rows: maxRowErrors + 500}, progress, batches)
+	if s.err != nil {
+		t.Fatal(s.err)
+	}
+	if s.totalRows != maxRowErrors+500 || s.invalidRows != maxRowErrors+500 {
+		t.Errorf("rows = %d, invalid = %d, want %d", s.totalRows, s.invalidRows, maxRowErrors+500)
+	}
+	if len(s.rowErrors) != maxRowErrors || s.rowErrors[0].Row != 1 {
+		t.Errorf("kept %d row errors starting at row %d, want the first %d", len(s.rowErrors), s.rowErrors[0].Row, maxRowErrors)
+	}
+}
@@ -0,0 +1,54 @@
+package processor
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tsv-processor/internal/models"
+)
+
+// JobProgress collects what a job has done so far. The row counters are
+// updated while a file is read; stages are added as they finish.
+type JobProgress struct {
+	RowsRead    atomic.Int64
+	RowsWritten atomic.Int64
+	InvalidRows atomic.Int64
+
+	mu     sync.Mutex
+	stages []models.JobStage
+}
+
+// startStage starts timing a stage of the job and returns the function that
+// records its duration. A stage that runs more than once, such as the ingest
+// of each member of an archive, adds up.
+func (p *JobProgress) startStage(name string) func() {
+	start := time.Now()
+
+	return func() {
+		elapsed := time.Since(start).Milliseconds()
+
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		for i := range p.stages {
+			if p.stages[i].Name == name {
+				p.stages[i].DurationMs += elapsed
+				return
+			}
+		}
+		p.stages = append(p.stages, models.JobStage{Name: name, DurationMs: elapsed})
+	}
+}
+
+func (p *JobProgress) stats() models.JobStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return models.JobStats{
+		RowsRead:    p.RowsRead.Load(),
+		RowsWritten: p.RowsWritten.Load(),
+		InvalidRows: p.InvalidRows.Load(),
+		Stages:      append([]models.JobStage(nil), p.stages...),
+	}
+}
@@ -0,0 +1,235 @@
+package processor
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tsv-processor/internal/config"
+)
+
+type fileState struct {
+	size    int64
+	modTime time.Time
+	since   time.Time
+}
+
+// readinessChecker decides whether a file in the input directory has been
+// completely written and may be queued. Files that are not ready are simply
+// left alone until a later scan.
+type readinessChecker struct {
+	strategy   string
+	stableFor  time.Duration
+	markers    []string
+	tempSuffix string
+
+	mu     sync.Mutex
+	states map[string]fileState
+	// renamed holds the files known to be complete under the rename
+	// strategy, and unwatched the input directories whose renames cannot be
+	// seen.
+	renamed   map[string]bool
+	unwatched []string
+}
+
+func newReadinessChecker(cfg config.ReadinessConfig) (*readinessChecker, error) {
+	c := &readinessChecker{
+		strategy:   cfg.GetStrategy(),
+		stableFor:  cfg.GetStableFor(),
+		markers:    cfg.GetMarkerSuffixes(),
+		tempSuffix: cfg.GetTempSuffix(),
+		states:     make(map[string]fileState),
+		renamed:    make(map[string]bool),
+	}
+
+	switch c.strategy {
+	case config.ReadinessNone, config.ReadinessStable, config.ReadinessMarker, config.ReadinessRename:
+	default:
+		return nil, fmt.Errorf("unknown readiness strategy %q", c.strategy)
+	}
+
+	return c, nil
+}
+
+func (c *readinessChecker) ready(filePath string, info os.FileInfo) bool {
+	if strings.HasSuffix(info.Name(), c.tempSuffix) {
+		return false
+	}
+
+	switch c.strategy {
+	case config.ReadinessStable:
+		return c.stable(filePath, info)
+	case config.ReadinessMarker:
+		return c.marked(filePath)
+	case config.ReadinessRename:
+		return c.wasRenamed(filePath)
+	}
+	return true
+}
+
+// auxiliary reports whether name is that of a marker file or of a file still
+// being written under its temporary name, neither of which is an input, even
+// for a source whose format is fixed and that takes any name.
+func (c *readinessChecker) auxiliary(name string) bool {
+	if strings.HasSuffix(name, c.tempSuffix) {
+		return true
+	}
+	for _, suffix := range c.markers {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// wasRenamed reports whether the file got its name by a rename from its
+// temporary name, or was already there when the directory watcher started.
+// Files written under their final name are never taken.
+func (c *readinessChecker) wasRenamed(filePath string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.renamed[filePath] {
+		return true
+	}
+	for _, root := range c.unwatched {
+		if strings.HasPrefix(filePath, root) {
+			return true
+		}
+	}
+	return false
+}
+
+// renamedFrom is told by the directory watcher about a file that was renamed
+// away. If that was the temporary name of a file, the file is complete.
+func (c *readinessChecker) renamedFrom(filePath string) {
+	if c.strategy != config.ReadinessRename || !strings.HasSuffix(filePath, c.tempSuffix) {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.renamed[strings.TrimSuffix(filePath, c.tempSuffix)] = true
+}
+
+// watching is told by the directory watcher about the files of an input
+// directory it starts watching. Those were written before and are taken as
+// complete under the rename strategy.
+func (c *readinessChecker) watching(entries []inputEntry) {
+	if c.strategy != config.ReadinessRename {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, entry := range entries {
+		c.renamed[entry.filePath] = true
+	}
+}
+
+// cannotWatch is told that renames under root cannot be seen. The rename
+// strategy then takes any file there that does not have the temporary
+// suffix, rather than none.
+func (c *readinessChecker) cannotWatch(root string) {
+	if c.strategy != config.ReadinessRename {
+		return
+	}
+
+	log.Printf("Renames in %s cannot be detected, files without the %s suffix are taken as complete", root, c.tempSuffix)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.unwatched = append(c.unwatched, filepath.Clean(root)+string(filepath.Separator))
+}
+
+// stable reports whether the size and modification time of the file have not
+// changed for the configured period. The modification time alone is not
+// enough, since copy tools often preserve the time of the source.
+func (c *readinessChecker) stable(filePath string, info os.FileInfo) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	state, seen := c.states[filePath]
+	if !seen || state.size != info.Size() || !state.modTime.Equal(info.ModTime()) {
+		c.states[filePath] = fileState{size: info.Size(), modTime: info.ModTime(), since: now}
+		return false
+	}
+
+	return now.Sub(state.since) >= c.stableFor
+}
+
+func (c *readinessChecker) marked(filePath string) bool {
+	for _, suffix := range c.markers {
+		if _, err := os.Stat(filePath + suffix); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// recheckAfter returns how long to wait before files that were not ready may
+// have become ready, and false if that is only known from a change to the
+// directory: a marker file appearing or a rename.
+func (c *readinessChecker) recheckAfter() (time.Duration, bool) {
+	if c.strategy == config.ReadinessStable {
+		return c.stableFor, true
+	}
+	return 0, false
+}
+
+// forget drops what is known about files under root that are no longer in
+// the input directory.
+func (c *readinessChecker) forget(root string, present map[string]bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	root = filepath.Clean(root) + string(filepath.Separator)
+	for filePath := range c.states {
+		if strings.HasPrefix(filePath, root) && !present[filePath] {
+			delete(c.states, filePath)
+		}
+	}
+	// A file may have been renamed in after the scan listed the directory,
+	// so only files that are really gone are dropped.
+	for filePath := range c.renamed {
+		if strings.HasPrefix(filePath, root) && !present[filePath] {
+			if _, err := os.Lstat(filePath); os.IsNotExist(err) {
+				delete(c.renamed, filePath)
+			}
+		}
+	}
+}
+
+// markReady makes a file that the service itself put back into the input
+// directory count as ready, by creating its marker file if markers are used.
+func (c *readinessChecker) markReady(filePath string) error {
+	if c.strategy != config.ReadinessMarker {
+		return nil
+	}
+
+	f, err := os.Create(filePath + c.markers[0])
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// release removes the marker files of an input that has left the input
+// directory, so that a later file with the same name is not taken as ready.
+func (c *readinessChecker) release(filePath string) {
+	if c.strategy != config.ReadinessMarker {
+		return
+	}
+
+	for _, suffix := range c.markers {
+		err := os.Remove(filePath + suffix)
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("Error removing marker file %s: %v", filePath+suffix, err)
+		}
+	}
+}
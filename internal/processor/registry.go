@@ -0,0 +1,138 @@
+package processor
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	FormatTSV    = "tsv"
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+// sniffExtensions are picked up from the input directory even though they do
+// not name a format; the format is then detected from the content.
+var sniffExtensions = map[string]bool{
+	".txt": true,
+}
+
+// Registry selects the Parser for an input file by its extension, falling
+// back to sniffing the beginning of the content.
+type Registry struct {
+	parsers    map[string]Parser
+	extensions map[string]string
+}
+
+func NewRegistry() *Registry {
+	r := &Registry{
+		parsers:    make(map[string]Parser),
+		extensions: make(map[string]string),
+	}
+
+	r.Register(FormatTSV, NewTSVParser(), ".tsv", ".tab")
+	r.Register(FormatCSV, NewCSVParser(), ".csv")
+	r.Register(FormatNDJSON, NewNDJSONParser(), ".ndjson", ".jsonl")
+
+	return r
+}
+
+func (r *Registry) Register(format string, parser Parser, extensions ...string) {
+	r.parsers[format] = parser
+	for _, ext := range extensions {
+		r.extensions[strings.ToLower(ext)] = format
+	}
+}
+
+// Restrict returns a registry that only knows the given formats. An empty
+// list keeps every registered format.
+func (r *Registry) Restrict(formats []string) (*Registry, error) {
+	if len(formats) == 0 {
+		return r, nil
+	}
+
+	restricted := &Registry{
+		parsers:    make(map[string]Parser),
+		extensions: make(map[string]string),
+	}
+
+	for _, format := range formats {
+		format = strings.ToLower(strings.TrimSpace(format))
+		parser, ok := r.parsers[format]
+		if !ok {
+			return nil, fmt.Errorf("unknown input format %q (supported: %s)", format, strings.Join(r.Formats(), ", "))
+		}
+		restricted.parsers[format] = parser
+	}
+
+	for ext, format := range r.extensions {
+		if _, ok := restricted.parsers[format]; ok {
+			restricted.extensions[ext] = format
+		}
+	}
+
+	return restricted, nil
+}
+
+func (r *Registry) Formats() []string {
+	formats := make([]string, 0, len(r.parsers))
+	for format := range r.parsers {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// Accepts reports whether a file with this name should be queued.
+func (r *Registry) Accepts(fileName string) bool {
+	ext := strings.ToLower(filepath.Ext(fileName))
+	_, known := r.extensions[ext]
+	return known || sniffExtensions[ext]
+}
+
+// Detect returns the format and parser for a file, given its name and the
+// first bytes of its content.
+func (r *Registry) Detect(fileName string, head []byte) (string, Parser, error) {
+	if format, ok := r.extensions[strings.ToLower(filepath.Ext(fileName))]; ok {
+		return format, r.parsers[format], nil
+	}
+
+	format := sniffFormat(head)
+	if format == "" {
+		return "", nil, fmt.Errorf("unrecognized input format")
+	}
+
+	parser, ok := r.parsers[format]
+	if !ok {
+		return "", nil, fmt.Errorf("input format %q is not enabled", format)
+	}
+	return format, parser, nil
+}
+
+func sniffFormat(head []byte) string {
+	head = bytes.TrimSpace(head)
+	if len(head) == 0 {
+		return ""
+	}
+	if head[0] == '{' {
+		return FormatNDJSON
+	}
+
+	firstLine := head
+	if i := bytes.IndexByte(head, '\n'); i >= 0 {
+		firstLine = head[:i]
+	}
+
+	tabs := bytes.Count(firstLine, []byte{'\t'})
+	separators := bytes.Count(firstLine, []byte{','}) + bytes.Count(firstLine, []byte{';'})
+	switch {
+	case tabs > 0 && tabs >= separators:
+		return FormatTSV
+	case separators > 0:
+		return FormatCSV
+	}
+	return ""
+}
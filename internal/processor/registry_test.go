@@ -0,0 +1,29 @@
+package processor
+
+import "testing"
+
+func TestSniffFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		head string
+		want string
+	}{
+		{"tsv", "n\tunit_guid\tmsg_id\n1\tg1\tm1\n", FormatTSV},
+		{"tsv with commas in text", "unit_guid\tmsg_id\ttext, more\n", FormatTSV},
+		{"csv", "n,unit_guid,msg_id\n1,g1,m1\n", FormatCSV},
+		{"semicolons", "n;unit_guid;msg_id\n", FormatCSV},
+		{"ndjson", `{"unit_guid":"g1","msg_id":"m1"}` + "\n", FormatNDJSON},
+		{"ndjson after blank lines", "\n\n  {\"unit_guid\":\"g1\"}\n", FormatNDJSON},
+		{"only the first line counts", "unit_guid\n\tmsg_id\n", ""},
+		{"single column", "unit_guid\n", ""},
+		{"empty", "  \n", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sniffFormat([]byte(tt.head)); got != tt.want {
+				t.Errorf("sniffFormat(%q) = %q, want %q", tt.head, got, tt.want)
+			}
+		})
+	}
+}
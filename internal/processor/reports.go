@@ -0,0 +1,197 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/tsv-processor/internal/config"
+	"github.com/tsv-processor/internal/generator"
+	"github.com/tsv-processor/internal/models"
+)
+
+var (
+	ErrUnknownSource   = errors.New("unknown source")
+	ErrUnknownTemplate = errors.New("unknown report template")
+)
+
+// loadTemplates reads the configured report templates. The built-in layout
+// is available as "default" unless a template file takes that name.
+func loadTemplates(cfg config.ReportsConfig) (map[string]*generator.Template, error) {
+	templates := map[string]*generator.Template{"default": generator.DefaultTemplate()}
+	for name, path := range cfg.Templates {
+		tmpl, err := generator.LoadTemplate(path)
+		if err != nil {
+			return nil, err
+		}
+		tmpl.Name = name
+		templates[name] = tmpl
+	}
+
+	if _, err := lookupTemplate(templates, cfg.Template); err != nil {
+		return nil, err
+	}
+	return templates, nil
+}
+
+func lookupTemplate(templates map[string]*generator.Template, name string) (*generator.Template, error) {
+	if name == "" {
+		name = "default"
+	}
+	tmpl, ok := templates[name]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownTemplate, name)
+	}
+	return tmpl, nil
+}
+
+// RequestReport queues a report of a device of a source with the named
+// template, or the template of the source if it is empty. Unlike the reports
+// requested by jobs it is due at once, unless it merges into a pending one.
+func (wp *WorkerPool) RequestReport(ctx context.Context, sourceName, unitGUID, template string) error {
+	if wp.source(sourceName) == nil {
+		return fmt.Errorf("%w %q", ErrUnknownSource, sourceName)
+	}
+	if template != "" {
+		if _, err := lookupTemplate(wp.templates, template); err != nil {
+			return err
+		}
+	}
+
+	req := &models.ReportRequest{
+		Source:   sourceName,
+		UnitGUID: unitGUID,
+		Template: template,
+	}
+	return wp.db.RequestReport(ctx, req, primitive.NilObjectID, 0)
+}
+
+// requestReports queues the reports of the devices touched by an input of a
+// job. The report workers generate them once the merge window has passed.
+func (wp *WorkerPool) requestReports(ctx context.Context, job Job, in inputFile, unitGUIDs []string) {
+	window := wp.cfg.Reports.GetWindow()
+	for _, unitGUID := range unitGUIDs {
+		req := &models.ReportRequest{
+			Source:   job.Source.Name,
+			UnitGUID: unitGUID,
+			FileName: in.Name,
+			FileHash: in.Hash,
+		}
+		if err := wp.db.RequestReport(ctx, req, job.ID, window); err != nil {
+			log.Printf("Error requesting report for unit_guid %s: %v", unitGUID, err)
+		}
+	}
+}
+
+func (wp *WorkerPool) reportWorker(ctx, jobCtx context.Context, id int) {
+	name := fmt.Sprintf("report-worker-%d", id)
+	owner := wp.instanceID + "/" + name
+	log.Printf("Worker %s started", name)
+
+	for {
+		req, err := wp.db.LeaseReport(ctx, owner, wp.cfg.GetJobVisibilityTimeout())
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Worker %s: error leasing report: %v", name, err)
+		}
+
+		if req == nil {
+			select {
+			case <-ctx.Done():
+				log.Printf("Worker %s stopping", name)
+				return
+			case <-time.After(wp.cfg.PollInterval):
+			}
+			continue
+		}
+
+		wp.runReport(jobCtx, owner, req)
+	}
+}
+
+func (wp *WorkerPool) runReport(ctx context.Context, owner string, req *models.ReportRequest) {
+	settleCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), settleTimeout)
+	defer cancel()
+
+	window := wp.cfg.Reports.GetWindow()
+
+	src := wp.source(req.Source)
+	if src == nil {
+		log.Printf("Dropping report for unit_guid %s: source %q is not configured", req.UnitGUID, req.Source)
+		if err := wp.db.FinishReport(settleCtx, req, owner, "", window); err != nil {
+			log.Printf("Error removing report request for %s: %v", req.UnitGUID, err)
+		}
+		return
+	}
+
+	reportPath, err := wp.generateReport(ctx, src, req)
+	if err == nil {
+		log.Printf("Generated report for %s (%d requests merged): %s", req.UnitGUID, req.Requests, reportPath)
+		if err := wp.db.FinishReport(settleCtx, req, owner, reportPath, window); err != nil {
+			log.Printf("Error completing report request for %s: %v", req.UnitGUID, err)
+		}
+		return
+	}
+
+	attempts := req.Attempts + 1
+	if isRetryable(err) && attempts < wp.cfg.Retry.GetMaxAttempts() {
+		delay := wp.backoff(attempts)
+		log.Printf("Error generating report for unit_guid %s, retrying in %s: %v", req.UnitGUID, delay, err)
+		if err := wp.db.RetryReport(settleCtx, req, owner, time.Now().Add(delay), err.Error()); err != nil {
+			log.Printf("Error rescheduling report for %s: %v", req.UnitGUID, err)
+		}
+		return
+	}
+
+	log.Printf("Error generating report for unit_guid %s: %v", req.UnitGUID, err)
+
+	procErr := &models.ProcessingError{
+		ID:        primitive.NewObjectID(),
+		UnitGUID:  req.UnitGUID,
+		ErrorMsg:  fmt.Sprintf("Report generation error: %v", err),
+		CreatedAt: time.Now(),
+	}
+	if saveErr := wp.db.SaveProcessingError(settleCtx, procErr); saveErr != nil {
+		log.Printf("Error saving processing error: %v", saveErr)
+	}
+
+	if err := wp.db.FinishReport(settleCtx, req, owner, "", window); err != nil {
+		log.Printf("Error removing report request for %s: %v", req.UnitGUID, err)
+	}
+}
+
+func (wp *WorkerPool) generateReport(ctx context.Context, src *source, req *models.ReportRequest) (string, error) {
+	tmpl := src.report
+	if req.Template != "" {
+		var err error
+		if tmpl, err = lookupTemplate(wp.templates, req.Template); err != nil {
+			return "", err
+		}
+	}
+
+	// Reports requested through the API name no file; the file scope then
+	// covers the latest file of the device.
+	if wp.reportScope == config.ReportScopeFile && req.FileName == "" {
+		var err error
+		req.FileName, req.FileHash, err = wp.db.GetLatestDeviceFile(ctx, req.Source, req.UnitGUID)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return "", fmt.Errorf("%w: %w", errDatabase, err)
+		}
+	}
+
+	data, err := wp.db.GetReportData(ctx, req, wp.reportScope)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", errDatabase, err)
+	}
+
+	return src.generator.GeneratePDF(req.UnitGUID, data, generator.ReportOptions{
+		Scope:    wp.reportScope,
+		FileName: req.FileName,
+		MaxRows:  wp.cfg.Reports.MaxRows,
+		Template: tmpl,
+	})
+}
@@ -0,0 +1,156 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/tsv-processor/internal/models"
+)
+
+// ReprocessedFile is the result of putting one file back into the pipeline.
+type ReprocessedFile struct {
+	Source      string `json:"source,omitempty"`
+	FileName    string `json:"file_name"`
+	From        string `json:"from,omitempty"`
+	DeletedRows int64  `json:"deleted_rows"`
+	Error       string `json:"error,omitempty"`
+}
+
+// Reprocess moves the files matching pattern, a file name or a glob, from the
+// archive and error directories back to the input directory, after removing
+// the data stored from them. Their records are kept, and the next attempt is
+// added to their history. Archive members are reprocessed with their archive.
+func (wp *WorkerPool) Reprocess(ctx context.Context, pattern string) ([]ReprocessedFile, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("%w: %q", err, pattern)
+	}
+
+	prefix := pattern
+	if i := strings.IndexAny(pattern, `*?[\`); i >= 0 {
+		prefix = pattern[:i]
+	}
+
+	records, err := wp.db.GetTopLevelFilesByPrefix(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	// Records are sorted newest first, so the latest version of each file
+	// decides where it is looked for.
+	type sourceFile struct{ source, name string }
+	seen := make(map[sourceFile]bool)
+	var latest []*models.ProcessedFile
+	for i := range records {
+		key := sourceFile{source: records[i].Source, name: records[i].FileName}
+		if seen[key] {
+			continue
+		}
+		if matched, _ := path.Match(pattern, records[i].FileName); !matched {
+			continue
+		}
+		seen[key] = true
+		latest = append(latest, &records[i])
+	}
+
+	results := make([]ReprocessedFile, 0, len(latest))
+	for _, record := range latest {
+		result := ReprocessedFile{Source: record.Source, FileName: record.FileName}
+		if err := wp.reprocessFile(ctx, record, &result); err != nil {
+			log.Printf("Error reprocessing file %s: %v", record.FileName, err)
+			result.Error = err.Error()
+		} else {
+			log.Printf("Reprocessing file %s from %s (%d rows removed)", record.FileName, result.From, result.DeletedRows)
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+func (wp *WorkerPool) reprocessFile(ctx context.Context, record *models.ProcessedFile, result *ReprocessedFile) error {
+	src := wp.source(record.Source)
+	if src == nil {
+		return fmt.Errorf("source %q is not configured", record.Source)
+	}
+
+	from, err := findProcessedFile(src, record)
+	if err != nil {
+		return err
+	}
+
+	dest := filepath.Join(src.InputDir, filepath.FromSlash(record.FileName))
+	if _, err := os.Stat(dest); err == nil {
+		return errors.New("file is already in the input directory")
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+
+	err = restoreInput(wp.readiness, from, dest, func() error {
+		return wp.db.RunInTransaction(ctx, func(txCtx context.Context) error {
+			deleted, err := wp.db.MarkForReprocess(txCtx, record.Source, record.FileName)
+			result.DeletedRows = deleted
+			return err
+		})
+	})
+	if err != nil {
+		return err
+	}
+	result.From = from
+	return nil
+}
+
+// restoreInput moves a processed file back into the input directory. It
+// first arrives under its temporary name, which no scan takes, while mark
+// flags its records; if that fails the file goes back where it came from.
+// It is then renamed into place, which is also what the rename readiness
+// strategy waits for, so that the watcher of a running service picks it up
+// even when the file was put back by the command line.
+func restoreInput(readiness *readinessChecker, from, dest string, mark func() error) error {
+	staging := dest + readiness.tempSuffix
+	if err := os.Rename(from, staging); err != nil {
+		return err
+	}
+
+	if err := mark(); err != nil {
+		if moveErr := os.Rename(staging, from); moveErr != nil {
+			log.Printf("Error moving %s back to %s: %v", staging, from, moveErr)
+		}
+		return err
+	}
+
+	if err := os.Rename(staging, dest); err != nil {
+		return err
+	}
+
+	if err := readiness.markReady(dest); err != nil {
+		log.Printf("Error creating marker file for %s: %v", dest, err)
+	}
+	return nil
+}
+
+// findProcessedFile returns where a processed file was moved to: the archive
+// directory for files that were loaded, the error directory for rejected
+// ones. The other directory is tried too, in case the file was processed
+// under the same name more than once.
+func findProcessedFile(src *source, record *models.ProcessedFile) (string, error) {
+	dirs := []string{"archive", "errors"}
+	if record.Status == "error" {
+		dirs[0], dirs[1] = dirs[1], dirs[0]
+	}
+
+	for _, dir := range dirs {
+		filePath := filepath.Join(src.OutputDir, dir, filepath.FromSlash(record.FileName))
+		if _, err := os.Stat(filePath); err == nil {
+			return filePath, nil
+		}
+	}
+
+	return "", errors.New("source file not found in archive or errors directory")
+}
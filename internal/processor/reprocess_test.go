@@ -0,0 +1,99 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tsv-processor/internal/config"
+)
+
+// TestRestoreInputRename checks that a running service under the rename
+// strategy takes a file put back into the input directory, whether by its
+// own API or by the command line, which has a readiness checker of its own.
+func TestRestoreInputRename(t *testing.T) {
+	tests := []struct {
+		name string
+		cli  bool
+	}{
+		{name: "api"},
+		{name: "cli", cli: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.ReadinessConfig{Strategy: config.ReadinessRename}
+			service, err := newReadinessChecker(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			src := &source{}
+			src.InputDir = t.TempDir()
+			wp := &WorkerPool{readiness: service, cfg: &config.WatcherConfig{PollInterval: time.Second}}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			wp.notifyChanges(ctx, src)
+
+			restorer := service
+			if tt.cli {
+				if restorer, err = newReadinessChecker(cfg); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			from := filepath.Join(t.TempDir(), "a.tsv")
+			if err := os.WriteFile(from, []byte("unit_guid\tmsg_id\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			dest := filepath.Join(src.InputDir, "a.tsv")
+			if err := restoreInput(restorer, from, dest, func() error { return nil }); err != nil {
+				t.Fatal(err)
+			}
+
+			deadline := time.Now().Add(2 * time.Second)
+			for {
+				info, err := os.Stat(dest)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if service.ready(dest, info) {
+					return
+				}
+				if time.Now().After(deadline) {
+					t.Fatal("restored file never became ready")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		})
+	}
+}
+
+func TestRestoreInputMarkFails(t *testing.T) {
+	readiness, err := newReadinessChecker(config.ReadinessConfig{Strategy: config.ReadinessRename})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	from := filepath.Join(t.TempDir(), "a.tsv")
+	if err := os.WriteFile(from, []byte("unit_guid\tmsg_id\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	input := t.TempDir()
+	dest := filepath.Join(input, "a.tsv")
+
+	errMark := errors.New("mark failed")
+	if err := restoreInput(readiness, from, dest, func() error { return errMark }); !errors.Is(err, errMark) {
+		t.Fatalf("error = %v, want %v", err, errMark)
+	}
+
+	if _, err := os.Stat(from); err != nil {
+		t.Errorf("file was not moved back: %v", err)
+	}
+	if entries, _ := os.ReadDir(input); len(entries) != 0 {
+		t.Errorf("input directory is not empty: %v", entries)
+	}
+}
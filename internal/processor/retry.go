@@ -0,0 +1,120 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+
+	"github.com/tsv-processor/internal/models"
+)
+
+// isRetryable tells transient failures, which are worth another attempt
+// later, from permanent ones such as a malformed file.
+func isRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, mongo.ErrClientDisconnected) {
+		return true
+	}
+	if mongo.IsNetworkError(err) || mongo.IsTimeout(err) {
+		return true
+	}
+
+	var selectionErr topology.ServerSelectionError
+	if errors.As(err, &selectionErr) {
+		return true
+	}
+
+	var labeled mongo.LabeledError
+	if errors.As(err, &labeled) {
+		return labeled.HasErrorLabel("RetryableWriteError") || labeled.HasErrorLabel("TransientTransactionError")
+	}
+
+	return false
+}
+
+// backoff returns the delay before the next attempt of a job that has failed
+// attempts times, doubling from the initial delay up to the maximum.
+func (wp *WorkerPool) backoff(attempts int) time.Duration {
+	delay := wp.cfg.Retry.GetInitialBackoff()
+	maxDelay := wp.cfg.Retry.GetMaxBackoff()
+
+	for i := 1; i < attempts && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	return delay
+}
+
+// settleJob records the result of a job in the queue: finished jobs are
+// acknowledged, rejected ones are failed, transient failures are rescheduled with backoff and jobs out
+// of attempts are moved to the dead-letter state.
+func (wp *WorkerPool) settleJob(ctx context.Context, owner string, job Job, outcome fileOutcome, jobErr error) {
+	stats := job.Progress.stats()
+
+	switch outcome {
+	case outcomeSuccess:
+		if err := wp.db.AckJob(ctx, job.ID, owner, stats); err != nil {
+			log.Printf("Error acknowledging job for %s: %v", job.FileName, err)
+		}
+		return
+	case outcomeRejected:
+		if err := wp.db.FailJob(ctx, job.ID, owner, jobErr.Error(), stats); err != nil {
+			log.Printf("Error marking job for %s as failed: %v", job.FileName, err)
+		}
+		return
+	}
+
+	if job.Attempts >= wp.cfg.Retry.GetMaxAttempts() {
+		log.Printf("Job for %s failed after %d attempts, moving to dead letter: %v", job.FileName, job.Attempts, jobErr)
+		if err := wp.db.DeadLetterJob(ctx, job.ID, owner, jobErr.Error(), stats); err != nil {
+			log.Printf("Error moving job for %s to dead letter: %v", job.FileName, err)
+		}
+		return
+	}
+
+	delay := wp.backoff(job.Attempts)
+	log.Printf("Job for %s failed on attempt %d, retrying in %s: %v", job.FileName, job.Attempts, delay, jobErr)
+	if err := wp.db.RetryJob(ctx, job.ID, owner, time.Now().Add(delay), jobErr.Error(), stats); err != nil {
+		log.Printf("Error rescheduling job for %s: %v", job.FileName, err)
+	}
+}
+
+// failInput records an input that could not be processed. Transient failures
+// leave the input in place for the next attempt of the job; anything else
+// rejects it.
+func (wp *WorkerPool) failInput(ctx context.Context, processedFile *models.ProcessedFile, err error) (fileOutcome, error) {
+	log.Printf("Error processing file %s: %v", processedFile.FileName, err)
+
+	// A job cancelled through the API rejects its input, so that it is not
+	// picked up again.
+	if errors.Is(context.Cause(ctx), errJobCancelled) {
+		wp.rejectInput(context.WithoutCancel(ctx), processedFile, errJobCancelled)
+		return outcomeRejected, errJobCancelled
+	}
+
+	if !isRetryable(err) {
+		wp.rejectInput(ctx, processedFile, err)
+		return outcomeRejected, err
+	}
+
+	// A job cancelled by a shutdown is not a failure of the input.
+	if ctx.Err() != nil {
+		return outcomeRetry, err
+	}
+
+	procErr := &models.ProcessingError{
+		FileName:  processedFile.FileName,
+		ErrorMsg:  err.Error(),
+		CreatedAt: time.Now(),
+	}
+	if saveErr := wp.db.SaveProcessingError(ctx, procErr); saveErr != nil {
+		log.Printf("Error saving processing error: %v", saveErr)
+	}
+
+	return outcomeRetry, err
+}
@@ -0,0 +1,154 @@
+package processor
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tsv-processor/internal/config"
+)
+
+const formatUUID = "uuid"
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// knownColumns lists the template columns in their template order.
+var knownColumns = []string{
+	colRowNum, colMQTT, colInventory, colUnitGUID, colMsgID, colText, colContext,
+	colClass, colLevel, colArea, colAddr, colBlock, colType, colBit, colInvertBit,
+}
+
+var integerColumns = map[string]bool{
+	colRowNum:    true,
+	colLevel:     true,
+	colBit:       true,
+	colInvertBit: true,
+}
+
+// Schema checks the raw values of a row before they are converted into a
+// DeviceData record. Integer columns and the required columns are always
+// checked; everything else comes from the validation config.
+type Schema struct {
+	rules []columnRule
+}
+
+type columnRule struct {
+	column   string
+	required bool
+	integer  bool
+	enum     map[string]bool
+	enumList string
+	min      *int
+	max      *int
+	pattern  *regexp.Regexp
+	format   string
+}
+
+func NewSchema(cfg config.ValidationConfig) (*Schema, error) {
+	position := make(map[string]int, len(knownColumns))
+	rules := make(map[string]*columnRule, len(knownColumns))
+	for i, column := range knownColumns {
+		position[column] = i
+		rules[column] = &columnRule{column: column, integer: integerColumns[column]}
+	}
+	for _, column := range requiredColumns {
+		rules[column].required = true
+	}
+
+	for name, ruleCfg := range cfg.Columns {
+		column := normalizeColumn(name)
+		rule, ok := rules[column]
+		if !ok {
+			return nil, fmt.Errorf("validation: unknown column %q", name)
+		}
+
+		rule.required = rule.required || ruleCfg.Required
+
+		if len(ruleCfg.Enum) > 0 {
+			rule.enum = make(map[string]bool, len(ruleCfg.Enum))
+			for _, value := range ruleCfg.Enum {
+				rule.enum[value] = true
+			}
+			rule.enumList = strings.Join(ruleCfg.Enum, ", ")
+		}
+
+		if ruleCfg.Min != nil || ruleCfg.Max != nil {
+			if !rule.integer {
+				return nil, fmt.Errorf("validation: column %q is not numeric, min/max do not apply", name)
+			}
+			rule.min, rule.max = ruleCfg.Min, ruleCfg.Max
+		}
+
+		switch {
+		case ruleCfg.Format == formatUUID:
+			rule.pattern = uuidPattern
+			rule.format = "a valid UUID"
+		case ruleCfg.Format != "":
+			return nil, fmt.Errorf("validation: unknown format %q for column %q", ruleCfg.Format, name)
+		}
+
+		if ruleCfg.Pattern != "" {
+			re, err := regexp.Compile("^(?:" + ruleCfg.Pattern + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("validation: invalid pattern for column %q: %w", name, err)
+			}
+			rule.pattern = re
+			rule.format = "pattern " + ruleCfg.Pattern
+		}
+	}
+
+	schema := &Schema{}
+	for _, rule := range rules {
+		schema.rules = append(schema.rules, *rule)
+	}
+	sort.Slice(schema.rules, func(i, j int) bool {
+		return position[schema.rules[i].column] < position[schema.rules[j].column]
+	})
+
+	return schema, nil
+}
+
+// Validate returns a RowError for the first value of the row that breaks the
+// schema.
+func (s *Schema) Validate(get fieldGetter, rowNum int) error {
+	for _, rule := range s.rules {
+		if reason := rule.check(get(rule.column)); reason != "" {
+			return RowError{Row: rowNum, Column: rule.column, Reason: reason}
+		}
+	}
+	return nil
+}
+
+func (r *columnRule) check(value string) string {
+	if value == "" {
+		if r.required {
+			return "required value is empty"
+		}
+		return ""
+	}
+
+	if r.integer {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Sprintf("%q is not an integer", value)
+		}
+		if r.min != nil && n < *r.min {
+			return fmt.Sprintf("%d is less than the minimum %d", n, *r.min)
+		}
+		if r.max != nil && n > *r.max {
+			return fmt.Sprintf("%d is greater than the maximum %d", n, *r.max)
+		}
+	}
+
+	if r.enum != nil && !r.enum[value] {
+		return fmt.Sprintf("%q is not one of: %s", value, r.enumList)
+	}
+
+	if r.pattern != nil && !r.pattern.MatchString(value) {
+		return fmt.Sprintf("%q does not match %s", value, r.format)
+	}
+
+	return ""
+}
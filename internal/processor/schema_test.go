@@ -0,0 +1,107 @@
+package processor
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/tsv-processor/internal/config"
+)
+
+func intPtr(n int) *int {
+	return &n
+}
+
+func TestSchemaValidate(t *testing.T) {
+	schema, err := NewSchema(config.ValidationConfig{
+		Columns: map[string]config.ColumnRule{
+			"unit_guid": {Format: "uuid"},
+			"inventory": {Pattern: `G-\d{6}`},
+			"class":     {Enum: []string{"alarm", "warning"}},
+			"level":     {Min: intPtr(1), Max: intPtr(5)},
+			"text":      {Required: true},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := map[string]string{
+		"n":         "7",
+		"unit_guid": "0b6f8a52-3c1e-4d8a-9f3b-2a1c5e7d9b40",
+		"msg_id":    "m1",
+		"invid":     "G-123456",
+		"class":     "alarm",
+		"level":     "3",
+		"text":      "pump stopped",
+	}
+
+	tests := []struct {
+		name    string
+		change  map[string]string
+		column  string
+		wantErr string
+	}{
+		{name: "valid row"},
+		{name: "optional value empty", change: map[string]string{"class": "", "level": ""}},
+		{name: "required column empty", change: map[string]string{"msg_id": ""}, column: "msg_id", wantErr: "required value is empty"},
+		{name: "required by config", change: map[string]string{"text": ""}, column: "text", wantErr: "required value is empty"},
+		{name: "not an integer", change: map[string]string{"n": "seven"}, column: "n", wantErr: "is not an integer"},
+		{name: "below minimum", change: map[string]string{"level": "0"}, column: "level", wantErr: "less than the minimum 1"},
+		{name: "above maximum", change: map[string]string{"level": "6"}, column: "level", wantErr: "greater than the maximum 5"},
+		{name: "not in enum", change: map[string]string{"class": "info"}, column: "class", wantErr: "is not one of: alarm, warning"},
+		{name: "not a uuid", change: map[string]string{"unit_guid": "g1"}, column: "unit_guid", wantErr: "a valid UUID"},
+		{name: "pattern is anchored", change: map[string]string{"invid": "xG-1234567"}, column: "invid", wantErr: "does not match pattern"},
+		{name: "first broken column in template order", change: map[string]string{"n": "x", "level": "9"}, column: "n", wantErr: "is not an integer"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			get := func(name string) string {
+				if v, ok := tt.change[name]; ok {
+					return v
+				}
+				return valid[name]
+			}
+
+			err := schema.Validate(get, 12)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			var rowErr RowError
+			if !errors.As(err, &rowErr) {
+				t.Fatalf("error = %v, want a RowError", err)
+			}
+			if rowErr.Row != 12 || rowErr.Column != tt.column || !strings.Contains(rowErr.Reason, tt.wantErr) {
+				t.Errorf("error = %+v, want row 12, column %q, reason containing %q", rowErr, tt.column, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewSchemaErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    config.ColumnRule
+		column  string
+		wantErr string
+	}{
+		{name: "unknown column", column: "colour", wantErr: "unknown column"},
+		{name: "min on text column", column: "text", rule: config.ColumnRule{Min: intPtr(1)}, wantErr: "not numeric"},
+		{name: "unknown format", column: "text", rule: config.ColumnRule{Format: "email"}, wantErr: "unknown format"},
+		{name: "invalid pattern", column: "text", rule: config.ColumnRule{Pattern: "("}, wantErr: "invalid pattern"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewSchema(config.ValidationConfig{Columns: map[string]config.ColumnRule{tt.column: tt.rule}})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
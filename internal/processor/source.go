@@ -0,0 +1,180 @@
+package processor
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/tsv-processor/internal/config"
+	"github.com/tsv-processor/internal/generator"
+)
+
+// source is one watched input directory with its own parser settings, output
+// directory and share of the workers.
+type source struct {
+	config.SourceConfig
+
+	// registry knows the formats enabled for the source; parser is set
+	// when the format of the source is fixed.
+	registry  *Registry
+	parser    Parser
+	template  pathTemplate
+	generator *generator.ReportGenerator
+	// report is the report template of the source.
+	report *generator.Template
+	wake   chan struct{}
+}
+
+func newSource(cfg config.SourceConfig, registry *Registry, templates map[string]*generator.Template) (*source, error) {
+	if _, err := path.Match(cfg.Pattern, ""); err != nil {
+		return nil, fmt.Errorf("source %s: invalid pattern %q: %w", cfg.Name, cfg.Pattern, err)
+	}
+
+	if cfg.Encoding != "" {
+		if _, err := lookupEncoding(cfg.Encoding); err != nil {
+			return nil, fmt.Errorf("source %s: %w", cfg.Name, err)
+		}
+	}
+
+	template, err := parsePathTemplate(cfg.PathTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("source %s: %w", cfg.Name, err)
+	}
+	if template != nil {
+		cfg.Recursive = true
+	}
+
+	report, err := lookupTemplate(templates, cfg.ReportTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("source %s: %w", cfg.Name, err)
+	}
+
+	registry, err = registry.Restrict(cfg.Formats)
+	if err != nil {
+		return nil, fmt.Errorf("source %s: %w", cfg.Name, err)
+	}
+
+	s := &source{
+		SourceConfig: cfg,
+		registry:     registry,
+		template:     template,
+		generator:    generator.NewReportGenerator(cfg.OutputDir),
+		report:       report,
+		wake:         make(chan struct{}, cfg.Workers),
+	}
+
+	if cfg.Format != "" {
+		cfg.Format = strings.ToLower(cfg.Format)
+		parser, ok := registry.parsers[cfg.Format]
+		if !ok {
+			return nil, fmt.Errorf("source %s: input format %q is not enabled", cfg.Name, cfg.Format)
+		}
+		s.Format, s.parser = cfg.Format, parser
+	}
+
+	return s, nil
+}
+
+// matches reports whether a file dropped into the source should be picked
+// up, given its slash-separated path relative to the input directory.
+func (s *source) matches(fileName string) bool {
+	if matched, _ := path.Match(s.Pattern, path.Base(fileName)); !matched {
+		return false
+	}
+	if s.template == nil {
+		return true
+	}
+	_, ok := s.template.match(path.Dir(fileName))
+	return ok
+}
+
+// metadata returns the path segments named by the template of the source.
+func (s *source) metadata(fileName string) map[string]string {
+	if s.template == nil {
+		return nil
+	}
+	metadata, _ := s.template.match(path.Dir(fileName))
+	return metadata
+}
+
+// detect returns the format and parser for a file of the source.
+func (s *source) detect(fileName string, head []byte) (string, Parser, error) {
+	if s.parser != nil {
+		return s.Format, s.parser, nil
+	}
+	return s.registry.Detect(fileName, head)
+}
+
+// accepts reports whether the registry can parse a file of the source with
+// this name; any name is accepted when the format is fixed.
+func (s *source) accepts(fileName string) bool {
+	return s.parser != nil || s.registry.Accepts(fileName)
+}
+
+func (s *source) String() string {
+	if s.Name == "" {
+		return s.InputDir
+	}
+	return s.Name
+}
+
+// pathTemplate describes the directory levels below an input directory, one
+// element per level: "{name}" captures the directory name as metadata, and
+// anything else is a glob the directory name must match.
+type pathTemplate []string
+
+func parsePathTemplate(template string) (pathTemplate, error) {
+	template = strings.Trim(template, "/")
+	if template == "" {
+		return nil, nil
+	}
+
+	segments := strings.Split(template, "/")
+	names := make(map[string]bool)
+	for _, segment := range segments {
+		if name, ok := templateName(segment); ok {
+			if name == "" || names[name] {
+				return nil, fmt.Errorf("invalid path template %q: empty or repeated name", template)
+			}
+			names[name] = true
+			continue
+		}
+		if _, err := path.Match(segment, ""); err != nil {
+			return nil, fmt.Errorf("invalid path template %q: %w", template, err)
+		}
+	}
+
+	return segments, nil
+}
+
+func templateName(segment string) (string, bool) {
+	if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+		return segment[1 : len(segment)-1], true
+	}
+	return "", false
+}
+
+// match returns the named segments of dir, a slash-separated path relative
+// to the input directory, and whether dir has the layout of the template.
+func (t pathTemplate) match(dir string) (map[string]string, bool) {
+	var parts []string
+	if dir != "." && dir != "" {
+		parts = strings.Split(dir, "/")
+	}
+	if len(parts) != len(t) {
+		return nil, false
+	}
+
+	metadata := make(map[string]string)
+	for i, segment := range t {
+		if name, ok := templateName(segment); ok {
+			metadata[name] = parts[i]
+			continue
+		}
+		if matched, _ := path.Match(segment, parts[i]); !matched {
+			return nil, false
+		}
+	}
+
+	return metadata, true
+}
@@ -0,0 +1,111 @@
+package processor
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tsv-processor/internal/config"
+)
+
+func TestPathTemplateMatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		dir      string
+		want     map[string]string
+		ok       bool
+	}{
+		{name: "no template, top level", template: "", dir: ".", want: map[string]string{}, ok: true},
+		{name: "no template, subdirectory", template: "", dir: "plant1"},
+		{name: "names", template: "{site}/{line}", dir: "plant1/line2", want: map[string]string{"site": "plant1", "line": "line2"}, ok: true},
+		{name: "glob", template: "{site}/20*", dir: "plant1/2024", want: map[string]string{"site": "plant1"}, ok: true},
+		{name: "glob mismatch", template: "{site}/20*", dir: "plant1/old"},
+		{name: "too shallow", template: "{site}/{line}", dir: "plant1"},
+		{name: "too deep", template: "{site}", dir: "plant1/line2"},
+		{name: "top level with template", template: "{site}", dir: "."},
+		{name: "slashes around template", template: "/{site}/", dir: "plant1", want: map[string]string{"site": "plant1"}, ok: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := parsePathTemplate(tt.template)
+			if err != nil {
+				t.Fatalf("parsePathTemplate(%q): %v", tt.template, err)
+			}
+
+			got, ok := tmpl.match(tt.dir)
+			if ok != tt.ok {
+				t.Fatalf("match(%q) ok = %v, want %v", tt.dir, ok, tt.ok)
+			}
+			if ok && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("match(%q) = %v, want %v", tt.dir, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePathTemplateErrors(t *testing.T) {
+	for _, template := range []string{"{site}/{site}", "{}/x", "[a"} {
+		if _, err := parsePathTemplate(template); err == nil {
+			t.Errorf("parsePathTemplate(%q) succeeded, want an error", template)
+		}
+	}
+}
+
+func TestAcceptsInput(t *testing.T) {
+	readiness, err := newReadinessChecker(config.ReadinessConfig{Strategy: config.ReadinessMarker})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wp := &WorkerPool{readiness: readiness}
+
+	detected := &source{registry: NewRegistry()}
+	fixed := &source{parser: NewTSVParser()}
+
+	tests := []struct {
+		name     string
+		detected bool
+		fixed    bool
+	}{
+		{name: "a.tsv", detected: true, fixed: true},
+		{name: "a.csv.gz", detected: true, fixed: true},
+		{name: "a.zip", detected: true, fixed: true},
+		{name: "export", detected: false, fixed: true},
+		{name: "a.tsv.done", detected: false, fixed: false},
+		{name: "a.tsv.ready", detected: false, fixed: false},
+		{name: "a.tsv.tmp", detected: false, fixed: false},
+	}
+
+	for _, tt := range tests {
+		if got := wp.acceptsInput(detected, tt.name); got != tt.detected {
+			t.Errorf("detected format: acceptsInput(%q) = %v, want %v", tt.name, got, tt.detected)
+		}
+		if got := wp.acceptsInput(fixed, tt.name); got != tt.fixed {
+			t.Errorf("fixed format: acceptsInput(%q) = %v, want %v", tt.name, got, tt.fixed)
+		}
+	}
+}
+
+func TestSourceFormats(t *testing.T) {
+	templates, err := loadTemplates(config.ReportsConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := newSource(config.SourceConfig{Name: "csv", Pattern: "*", Formats: []string{"csv"}}, NewRegistry(), templates)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]bool{"a.csv": true, "a.tsv": false, "a.ndjson": false} {
+		if got := src.accepts(name); got != want {
+			t.Errorf("accepts(%q) = %v, want %v", name, got, want)
+		}
+	}
+
+	_, err = newSource(config.SourceConfig{Name: "tsv", Pattern: "*", Formats: []string{"csv"}, Format: "tsv"}, NewRegistry(), templates)
+	if err == nil || !strings.Contains(err.Error(), "not enabled") {
+		t.Errorf("error = %v, want the fixed format to be rejected", err)
+	}
+}
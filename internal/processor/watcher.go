@@ -0,0 +1,282 @@
+package processor
+
+import (
+	"context"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// settleDelay coalesces the burst of events produced while a file is written.
+const settleDelay = 500 * time.Millisecond
+
+// watchDirectory scans the input directory whenever it changes. The poll
+// ticker stays as a periodic reconcile pass for events that were missed or
+// never delivered, as on network mounts.
+func (wp *WorkerPool) watchDirectory(ctx context.Context, src *source) {
+	ticker := time.NewTicker(wp.cfg.PollInterval)
+	defer ticker.Stop()
+
+	events := wp.notifyChanges(ctx, src)
+
+	// settle fires right away for the initial scan on startup.
+	settle := time.NewTimer(0)
+	defer settle.Stop()
+
+	// recheck fires when files were seen that were not stable yet, so that
+	// they are picked up as soon as they can be rather than on the next tick.
+	recheck := time.NewTimer(0)
+	recheck.Stop()
+	defer recheck.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-events:
+			settle.Reset(settleDelay)
+			continue
+		case <-settle.C:
+		case <-recheck.C:
+		case <-ticker.C:
+		}
+
+		if !wp.leader.Load() {
+			continue
+		}
+
+		if pending := wp.scanDirectory(ctx, src); pending {
+			if after, ok := wp.readiness.recheckAfter(); ok {
+				recheck.Reset(after)
+			}
+		}
+	}
+}
+
+// notifyChanges returns a channel that receives a value whenever the input
+// directory changes. If change notification is not available the channel
+// never fires and scanning relies on polling alone.
+func (wp *WorkerPool) notifyChanges(ctx context.Context, src *source) <-chan struct{} {
+	changes := make(chan struct{}, 1)
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Printf("Change notification unavailable, polling every %s: %v", wp.cfg.PollInterval, err)
+		wp.readiness.cannotWatch(src.InputDir)
+		return changes
+	}
+
+	if err := watchTree(watcher, src, src.InputDir); err != nil {
+		log.Printf("Cannot watch %s, polling every %s: %v", src.InputDir, wp.cfg.PollInterval, err)
+		wp.readiness.cannotWatch(src.InputDir)
+		watcher.Close()
+		return changes
+	}
+
+	// Files that are already there were written before the service
+	// started; their renames, if any, happened before watching.
+	if entries, err := listInputFiles(src); err == nil {
+		wp.readiness.watching(entries)
+	}
+
+	go func() {
+		defer watcher.Close()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) && !event.Has(fsnotify.Rename) {
+					continue
+				}
+				// A rename is reported for the old name, followed by a
+				// create for the new one.
+				if event.Has(fsnotify.Rename) {
+					wp.readiness.renamedFrom(event.Name)
+				}
+				// fsnotify does not watch subdirectories by itself, so new
+				// ones are added as they appear.
+				if event.Has(fsnotify.Create) && src.Recursive {
+					if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+						if err := watchTree(watcher, src, event.Name); err != nil {
+							log.Printf("Cannot watch %s: %v", event.Name, err)
+						}
+					}
+				}
+				select {
+				case changes <- struct{}{}:
+				default:
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("Error watching directory: %v", err)
+			}
+		}
+	}()
+
+	return changes
+}
+
+// watchTree adds dir to the watcher, with its subdirectories if the source
+// is recursive.
+func watchTree(watcher *fsnotify.Watcher, src *source, dir string) error {
+	if !src.Recursive {
+		return watcher.Add(dir)
+	}
+
+	return filepath.WalkDir(dir, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return watcher.Add(filePath)
+		}
+		return nil
+	})
+}
+
+type inputEntry struct {
+	fs.DirEntry
+	// fileName is the slash-separated path relative to the input directory,
+	// which names the file in the database and under the output directory.
+	fileName string
+	filePath string
+}
+
+// listInputFiles returns the files of the input directory of a source, and
+// of its subdirectories if the source is recursive.
+func listInputFiles(src *source) ([]inputEntry, error) {
+	var entries []inputEntry
+
+	err := filepath.WalkDir(src.InputDir, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if filePath == src.InputDir {
+				return err
+			}
+			log.Printf("Error scanning %s: %v", filePath, err)
+			return nil
+		}
+
+		if entry.IsDir() {
+			if filePath != src.InputDir && !src.Recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		rel, err := filepath.Rel(src.InputDir, filePath)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, inputEntry{
+			DirEntry: entry,
+			fileName: filepath.ToSlash(rel),
+			filePath: filePath,
+		})
+		return nil
+	})
+
+	return entries, err
+}
+
+type scanCandidate struct {
+	fileName    string
+	filePath    string
+	contentHash string
+}
+
+type fileKey struct {
+	name string
+	hash string
+}
+
+// scanDirectory queues the ready files of the input directory of a source
+// that have not been processed yet and reports whether any file is still
+// waiting to become ready.
+func (wp *WorkerPool) scanDirectory(ctx context.Context, src *source) (pending bool) {
+	entries, err := listInputFiles(src)
+	if err != nil {
+		log.Printf("Error scanning directory %s: %v", src.InputDir, err)
+		return false
+	}
+
+	present := make(map[string]bool, len(entries))
+	defer wp.readiness.forget(src.InputDir, present)
+	defer wp.hashes.forget(src.InputDir, present)
+
+	var candidates []scanCandidate
+	for _, entry := range entries {
+		if !src.matches(entry.fileName) || !wp.acceptsInput(src, path.Base(entry.fileName)) {
+			continue
+		}
+
+		fileName := entry.fileName
+		filePath := entry.filePath
+		present[filePath] = true
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		if !wp.readiness.ready(filePath, info) {
+			pending = true
+			continue
+		}
+
+		contentHash, err := wp.hashes.hash(filePath, info)
+		if err != nil {
+			log.Printf("Error hashing file %s: %v", fileName, err)
+			continue
+		}
+
+		candidates = append(candidates, scanCandidate{
+			fileName:    fileName,
+			filePath:    filePath,
+			contentHash: contentHash,
+		})
+	}
+
+	if len(candidates) == 0 {
+		return pending
+	}
+
+	names := make([]string, len(candidates))
+	for i, c := range candidates {
+		names[i] = c.fileName
+	}
+
+	processed, err := wp.db.GetProcessedFilesByNames(ctx, src.Name, names)
+	if err != nil {
+		log.Printf("Error checking processed files: %v", err)
+		return pending
+	}
+
+	seen := make(map[fileKey]bool, len(processed))
+	for _, p := range processed {
+		if !p.ReprocessRequested {
+			seen[fileKey{name: p.FileName, hash: p.ContentHash}] = true
+		}
+	}
+
+	for _, c := range candidates {
+		if seen[fileKey{name: c.fileName, hash: c.contentHash}] {
+			continue
+		}
+
+		wp.enqueue(ctx, src, c.filePath, c.fileName, c.contentHash)
+	}
+
+	return pending
+}
@@ -2,10 +2,14 @@ package processor
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -17,218 +21,611 @@ import (
 )
 
 type Job struct {
-	FilePath string
-	FileName string
+	ID          primitive.ObjectID
+	Source      *source
+	FilePath    string
+	FileName    string
+	ContentHash string
+	Attempts    int
+	Progress    *JobProgress
 }
 
+// inputFile is one logical input: a file from the input directory or a
+// member of an archive.
+type inputFile struct {
+	Name   string
+	Parent string
+	Hash   string
+	Open   func() (io.ReadCloser, error)
+}
+
+type fileOutcome int
+
+// Outcomes are ordered so that an archive takes the highest outcome of its
+// members: one rejected member rejects the archive, and one member to retry
+// makes the whole archive be retried.
+const (
+	outcomeSuccess fileOutcome = iota
+	outcomeRejected
+	outcomeRetry
+)
+
 type WorkerPool struct {
-	db        *db.MongoDB
-	parser    *TSVParser
-	generator *generator.ReportGenerator
-	jobQueue  chan Job
-	workers   int
-	cfg       *config.WatcherConfig
+	db           *db.MongoDB
+	schema       *Schema
+	maxErrorRate float64
+	dupPolicy    string
+	reportScope  string
+	templates    map[string]*generator.Template
+	readiness    *readinessChecker
+	hashes       *hashCache
+	sources      []*source
+	instanceID   string
+	cfg          *config.WatcherConfig
+
+	stop    context.CancelFunc // stops scanning and leasing new jobs
+	abort   context.CancelFunc // cancels the jobs that are still running
+	running sync.WaitGroup
+
+	// leader is set while this instance may scan the input directories.
+	leader atomic.Bool
 }
 
-func NewWorkerPool(db *db.MongoDB, cfg *config.WatcherConfig) *WorkerPool {
-	return &WorkerPool{
-		db:        db,
-		parser:    NewTSVParser(),
-		generator: generator.NewReportGenerator(cfg.OutputDir),
-		jobQueue:  make(chan Job, 100),
-		workers:   cfg.Workers,
-		cfg:       cfg,
+// settleTimeout bounds the queue update that records the result of a job,
+// which may run after the job itself has been cancelled.
+const settleTimeout = 10 * time.Second
+
+// cancelPollInterval is how often a running job checks whether a cancel has
+// been requested through the API.
+const cancelPollInterval = time.Second
+
+var errJobCancelled = errors.New("job cancelled")
+
+func NewWorkerPool(db *db.MongoDB, cfg *config.WatcherConfig) (*WorkerPool, error) {
+	// Sources restrict the full registry to their own formats, which
+	// default to the watcher-wide ones.
+	registry := NewRegistry()
+	if _, err := registry.Restrict(cfg.Formats); err != nil {
+		return nil, err
+	}
+
+	schema, err := NewSchema(cfg.Validation)
+	if err != nil {
+		return nil, err
+	}
+
+	maxErrorRate, err := cfg.GetMaxErrorRate()
+	if err != nil {
+		return nil, err
+	}
+
+	dupPolicy, err := cfg.GetDuplicateNamePolicy()
+	if err != nil {
+		return nil, err
 	}
+
+	reportScope, err := cfg.Reports.GetScope()
+	if err != nil {
+		return nil, err
+	}
+
+	readiness, err := newReadinessChecker(cfg.Readiness)
+	if err != nil {
+		return nil, err
+	}
+
+	templates, err := loadTemplates(cfg.Reports)
+	if err != nil {
+		return nil, err
+	}
+
+	sourceConfigs, err := cfg.GetSources()
+	if err != nil {
+		return nil, err
+	}
+
+	sources := make([]*source, len(sourceConfigs))
+	for i, sourceCfg := range sourceConfigs {
+		if sources[i], err = newSource(sourceCfg, registry, templates); err != nil {
+			return nil, err
+		}
+	}
+
+	return &WorkerPool{
+		db:           db,
+		schema:       schema,
+		maxErrorRate: maxErrorRate,
+		dupPolicy:    dupPolicy,
+		reportScope:  reportScope,
+		templates:    templates,
+		readiness:    readiness,
+		hashes:       newHashCache(),
+		sources:      sources,
+		instanceID:   instanceID(),
+		cfg:          cfg,
+	}, nil
 }
 
+// Start runs the workers, the report workers and the directory scanners until Shutdown is called
+// or ctx is cancelled. Cancelling ctx only stops taking new work; running
+// jobs are cancelled by Shutdown.
 func (wp *WorkerPool) Start(ctx context.Context) {
-	for i := 0; i < wp.workers; i++ {
-		go wp.worker(ctx, i)
+	ctx, wp.stop = context.WithCancel(ctx)
+	jobCtx, abort := context.WithCancel(context.WithoutCancel(ctx))
+	wp.abort = abort
+
+	if wp.cfg.LeaderElection {
+		wp.running.Add(1)
+		go func() {
+			defer wp.running.Done()
+			wp.keepLeadership(ctx)
+		}()
+	} else {
+		wp.leader.Store(true)
+	}
+
+	for _, src := range wp.sources {
+		for i := 0; i < src.Workers; i++ {
+			wp.running.Add(1)
+			go func(src *source, id int) {
+				defer wp.running.Done()
+				wp.worker(ctx, jobCtx, src, id)
+			}(src, i)
+		}
+
+		wp.running.Add(1)
+		go func(src *source) {
+			defer wp.running.Done()
+			wp.watchDirectory(ctx, src)
+		}(src)
+	}
+
+	for i := 0; i < wp.cfg.Reports.GetWorkers(); i++ {
+		wp.running.Add(1)
+		go func(id int) {
+			defer wp.running.Done()
+			wp.reportWorker(ctx, jobCtx, id)
+		}(i)
+	}
+}
+
+// source returns the source with the given name, or nil if it is no longer
+// configured.
+func (wp *WorkerPool) source(name string) *source {
+	for _, src := range wp.sources {
+		if src.Name == name {
+			return src
+		}
+	}
+	return nil
+}
+
+// Shutdown stops scanning and taking new jobs and waits for the running jobs
+// to finish. Jobs still running when ctx expires are cancelled and marked
+// interrupted, so that they are taken again on the next start.
+func (wp *WorkerPool) Shutdown(ctx context.Context) error {
+	wp.stop()
+
+	done := make(chan struct{})
+	go func() {
+		wp.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		wp.abort()
+		return nil
+	case <-ctx.Done():
 	}
 
-	go wp.watchDirectory(ctx)
+	log.Printf("Shutdown timeout reached, interrupting running jobs")
+	wp.abort()
+	<-done
+	return ctx.Err()
 }
 
-func (wp *WorkerPool) watchDirectory(ctx context.Context) {
-	ticker := time.NewTicker(wp.cfg.PollInterval)
-	defer ticker.Stop()
+func (wp *WorkerPool) worker(ctx, jobCtx context.Context, src *source, id int) {
+	name := fmt.Sprintf("worker-%d", id)
+	if src.Name != "" {
+		name = src.Name + "/" + name
+	}
+	owner := wp.instanceID + "/" + name
+	log.Printf("Worker %s started", name)
 
 	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-ticker.C:
-			wp.scanDirectory()
+		leased, err := wp.db.LeaseJob(ctx, owner, src.Name, wp.cfg.GetJobVisibilityTimeout())
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Worker %s: error leasing job: %v", name, err)
 		}
+
+		if leased == nil {
+			select {
+			case <-ctx.Done():
+				log.Printf("Worker %s stopping", name)
+				return
+			case <-src.wake:
+			case <-time.After(wp.cfg.PollInterval):
+			}
+			continue
+		}
+
+		wp.runJob(jobCtx, owner, src, leased)
 	}
 }
 
-func (wp *WorkerPool) scanDirectory() {
-	files, err := filepath.Glob(filepath.Join(wp.cfg.InputDir, "*.tsv"))
+func (wp *WorkerPool) runJob(ctx context.Context, owner string, src *source, leased *models.Job) {
+	job := Job{
+		ID:          leased.ID,
+		Source:      src,
+		FilePath:    leased.FilePath,
+		FileName:    leased.FileName,
+		ContentHash: leased.ContentHash,
+		Attempts:    leased.Attempts,
+		Progress:    &JobProgress{},
+	}
+
+	settleCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), settleTimeout)
+	defer cancel()
+
+	// The job lease keeps other workers off this job; the claim keeps them
+	// off its file, which a duplicate job or another instance may also have.
+	claim := fileClaim(job)
+	claimed, err := wp.db.AcquireLease(ctx, claim, owner, wp.cfg.GetJobVisibilityTimeout())
 	if err != nil {
-		log.Printf("Error scanning directory: %v", err)
+		wp.settleJob(settleCtx, owner, job, outcomeRetry, fmt.Errorf("%w: %w", errDatabase, err))
+		return
+	}
+	if !claimed {
+		log.Printf("File %s is being processed by another worker, postponing job", job.FileName)
+		if err := wp.db.PostponeJob(settleCtx, job.ID, owner, time.Now().Add(wp.cfg.PollInterval)); err != nil {
+			log.Printf("Error postponing job for %s: %v", job.FileName, err)
+		}
+		return
+	}
+	defer func() {
+		if err := wp.db.ReleaseLease(settleCtx, claim, owner); err != nil {
+			log.Printf("Error releasing claim on %s: %v", job.FileName, err)
+		}
+	}()
+
+	if _, err := os.Stat(job.FilePath); os.IsNotExist(err) {
+		wp.settleMissingFile(settleCtx, owner, job)
 		return
 	}
 
-	for _, filePath := range files {
-		fileName := filepath.Base(filePath)
+	if job.Attempts > 1 {
+		log.Printf("Retrying job for %s, attempt %d", job.FileName, job.Attempts)
+	}
 
-		processed, err := wp.db.IsFileProcessed(context.Background(), fileName)
-		if err != nil {
-			log.Printf("Error checking processed file: %v", err)
-			continue
-		}
+	jobCtx, cancelJob := context.WithCancelCause(ctx)
+	defer cancelJob(nil)
 
-		if processed {
-			log.Printf("File already processed: %s", fileName)
-			continue
+	stopLease := wp.keepLease(jobCtx, owner, job, cancelJob)
+	outcome, err := wp.processJob(jobCtx, job)
+	stopLease()
+
+	if ctx.Err() != nil {
+		log.Printf("Job for %s interrupted by shutdown", job.FileName)
+		if err := wp.db.InterruptJob(settleCtx, job.ID, owner, job.Progress.stats()); err != nil {
+			log.Printf("Error marking job for %s as interrupted: %v", job.FileName, err)
 		}
+		return
+	}
 
-		select {
-		case wp.jobQueue <- Job{
-			FilePath: filePath,
-			FileName: fileName,
-		}:
-			log.Printf("Added job to queue: %s", fileName)
-		default:
-			log.Printf("Job queue is full, skipping: %s", fileName)
+	wp.settleJob(settleCtx, owner, job, outcome, err)
+}
+
+// keepLease extends the lease of a job and the claim on its file while it is
+// being processed, so that long files are not handed to another worker, and
+// cancels the job when a cancel is requested through the API. The returned
+// function stops it.
+func (wp *WorkerPool) keepLease(ctx context.Context, owner string, job Job, cancelJob context.CancelCauseFunc) func() {
+	visibility := wp.cfg.GetJobVisibilityTimeout()
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(visibility / 3)
+		defer ticker.Stop()
+
+		cancelTicker := time.NewTicker(cancelPollInterval)
+		defer cancelTicker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := wp.db.ExtendLease(ctx, job.ID, owner, visibility, job.Progress.stats()); err != nil && ctx.Err() == nil {
+					log.Printf("Error extending lease of job for %s: %v", job.FileName, err)
+				}
+				if _, err := wp.db.AcquireLease(ctx, fileClaim(job), owner, visibility); err != nil && ctx.Err() == nil {
+					log.Printf("Error extending claim on %s: %v", job.FileName, err)
+				}
+			case <-cancelTicker.C:
+				requested, err := wp.db.IsJobCancelRequested(ctx, job.ID, owner)
+				if err != nil && ctx.Err() == nil {
+					log.Printf("Error checking cancel of job for %s: %v", job.FileName, err)
+				}
+				if requested {
+					log.Printf("Cancelling job for %s on request", job.FileName)
+					cancelJob(errJobCancelled)
+					return
+				}
+			}
 		}
+	}()
+
+	return func() {
+		cancel()
+		<-done
 	}
 }
 
-func (wp *WorkerPool) worker(ctx context.Context, id int) {
-	log.Printf("Worker %d started", id)
+func (wp *WorkerPool) enqueue(ctx context.Context, src *source, filePath, fileName, contentHash string) {
+	created, err := wp.db.EnqueueJob(ctx, &models.Job{
+		Source:      src.Name,
+		FilePath:    filePath,
+		FileName:    fileName,
+		ContentHash: contentHash,
+	})
+	if err != nil {
+		log.Printf("Error adding job for %s: %v", fileName, err)
+		return
+	}
+	if !created {
+		return
+	}
+
+	log.Printf("Added job to queue: %s", fileName)
+	select {
+	case src.wake <- struct{}{}:
+	default:
+	}
+}
 
-	for {
-		select {
-		case <-ctx.Done():
-			log.Printf("Worker %d stopping", id)
-			return
-		case job := <-wp.jobQueue:
-			wp.processJob(ctx, job)
-		}
+func instanceID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
 	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
 }
 
-func (wp *WorkerPool) processJob(ctx context.Context, job Job) {
+func (wp *WorkerPool) processJob(ctx context.Context, job Job) (fileOutcome, error) {
 	log.Printf("Worker processing file: %s", job.FileName)
 
+	if isZipArchive(job.FileName) {
+		return wp.processArchive(ctx, job)
+	}
+
+	outcome, err := wp.processInput(ctx, job, inputFile{
+		Name: job.FileName,
+		Hash: job.ContentHash,
+		Open: func() (io.ReadCloser, error) {
+			return os.Open(job.FilePath)
+		},
+	})
+	wp.finishFile(job, outcome)
+	return outcome, err
+}
+
+// processInput ingests one logical input file: a file from the input
+// directory or a member of an archive. It records the result but leaves
+// moving the source file to the caller.
+func (wp *WorkerPool) processInput(ctx context.Context, job Job, in inputFile) (fileOutcome, error) {
+	name := in.Name
 	processedFile := &models.ProcessedFile{
-		ID:          primitive.NewObjectID(),
-		FileName:    job.FileName,
-		FilePath:    job.FilePath,
-		ProcessedAt: time.Now(),
-		Status:      "success",
+		ID:            primitive.NewObjectID(),
+		FileName:      name,
+		FilePath:      job.FilePath,
+		ParentArchive: in.Parent,
+		Source:        job.Source.Name,
+		Site:          job.Source.Site,
+		Metadata:      job.Source.metadata(job.FileName),
+		ContentHash:   in.Hash,
+		ProcessedAt:   time.Now(),
+		Status:        "success",
 	}
 
-	records, err := wp.parser.Parse(job.FilePath, job.FileName)
+	endStage := job.Progress.startStage("dedup")
+	skip, err := wp.checkDuplicate(ctx, in, processedFile)
+	endStage()
 	if err != nil {
-		log.Printf("Error parsing file %s: %v", job.FileName, err)
-		processedFile.Status = "error"
-		processedFile.ErrorMsg = err.Error()
+		return wp.failInput(ctx, processedFile, err)
+	}
+	if skip {
+		return outcomeSuccess, nil
+	}
 
-		procErr := &models.ProcessingError{
-			ID:        primitive.NewObjectID(),
-			FileName:  job.FileName,
-			ErrorMsg:  err.Error(),
-			CreatedAt: time.Now(),
+	// Without transactions a crashed earlier attempt may have left rows
+	// behind; they are removed so that a rerun ends in the same state.
+	if !wp.db.SupportsTransactions() {
+		wp.discardFileData(ctx, job, in)
+	}
+
+	endStage = job.Progress.startStage("ingest")
+	var result *ingestResult
+	err = wp.db.RunInTransaction(ctx, func(txCtx context.Context) error {
+		var err error
+		result, err = wp.ingest(txCtx, job, in)
+		if err != nil {
+			return err
 		}
 
-		if saveErr := wp.db.SaveProcessingError(ctx, procErr); saveErr != nil {
-			log.Printf("Error saving processing error: %v", saveErr)
+		processedFile.Format = result.Format
+		processedFile.Encoding = result.Encoding
+		processedFile.TotalRows = result.TotalRows
+		processedFile.InvalidRows = result.InvalidRows
+
+		if rate := result.ErrorRate(); rate > wp.maxErrorRate {
+			return fmt.Errorf("%w: %d of %d (%.1f%%)", errTooManyInvalidRows, result.InvalidRows, result.TotalRows, rate*100)
 		}
 
-		if saveErr := wp.db.SaveProcessedFile(ctx, processedFile); saveErr != nil {
-			log.Printf("Error saving processed file record: %v", saveErr)
+		if result.InvalidRows > 0 {
+			processedFile.Status = "partial"
 		}
+		return wp.commitFile(txCtx, job, in, processedFile, result.RowErrors)
+	})
 
-		wp.moveFileToError(job.FilePath, job.FileName)
-		return
+	if err != nil && !wp.db.SupportsTransactions() {
+		wp.discardFileData(ctx, job, in)
 	}
+	endStage()
 
-	if err := wp.db.SaveDeviceData(ctx, records); err != nil {
-		log.Printf("Error saving data for file %s: %v", job.FileName, err)
+	if err == nil || errors.Is(err, errTooManyInvalidRows) {
+		job.Progress.InvalidRows.Add(int64(result.InvalidRows))
+	}
+
+	if errors.Is(err, errTooManyInvalidRows) {
+		log.Printf("Rejecting file %s: %d of %d rows are invalid", name, processedFile.InvalidRows, processedFile.TotalRows)
+		if saveErr := wp.saveRowErrors(ctx, job, in, result.RowErrors); saveErr != nil {
+			log.Printf("Error saving row errors for file %s: %v", name, saveErr)
+		}
 		processedFile.Status = "error"
-		processedFile.ErrorMsg = fmt.Sprintf("DB error: %v", err)
+		processedFile.ErrorMsg = err.Error()
 
 		if saveErr := wp.db.SaveProcessedFile(ctx, processedFile); saveErr != nil {
 			log.Printf("Error saving processed file record: %v", saveErr)
 		}
-		return
+		return outcomeRejected, err
 	}
 
-	unitGUIDs := wp.getUniqueUnitGUIDs(records)
-	for _, unitGUID := range unitGUIDs {
-		paginated, err := wp.db.GetDeviceDataByUnitGUID(ctx, unitGUID, 1, 1000)
-		if err != nil {
-			log.Printf("Error fetching data for unit_guid %s: %v", unitGUID, err)
-			continue
-		}
+	if err != nil {
+		return wp.failInput(ctx, processedFile, err)
+	}
 
-		reportPath, err := wp.generator.GeneratePDF(unitGUID, paginated.Data)
-		if err != nil {
-			log.Printf("Error generating report for unit_guid %s: %v", unitGUID, err)
-
-			procErr := &models.ProcessingError{
-				ID:        primitive.NewObjectID(),
-				FileName:  job.FileName,
-				UnitGUID:  unitGUID,
-				ErrorMsg:  fmt.Sprintf("Report generation error: %v", err),
-				CreatedAt: time.Now(),
-			}
+	endStage = job.Progress.startStage("reports")
+	wp.requestReports(ctx, job, in, result.UnitGUIDs)
+	endStage()
 
-			if saveErr := wp.db.SaveProcessingError(ctx, procErr); saveErr != nil {
-				log.Printf("Error saving processing error: %v", saveErr)
-			}
-			continue
+	log.Printf("Successfully processed file: %s (%d records, %d invalid rows)", name, result.RowsStored, result.InvalidRows)
+	return outcomeSuccess, nil
+}
+
+// commitFile saves the row errors and the record of a stored file, after
+// removing its other versions under the replace policy. It runs in the
+// transaction of the ingest; without transactions the record is written
+// last, since its presence is what marks the data of the file as complete.
+func (wp *WorkerPool) commitFile(ctx context.Context, job Job, in inputFile, processedFile *models.ProcessedFile, rowErrors []RowError) error {
+	if wp.dupPolicy == config.DuplicateNameReplace {
+		if err := wp.db.DeleteOtherFileVersions(ctx, job.Source.Name, in.Name, in.Hash); err != nil {
+			return fmt.Errorf("%w: %w", errDatabase, err)
 		}
+	}
 
-		log.Printf("Generated report for %s: %s", unitGUID, reportPath)
+	if err := wp.saveRowErrors(ctx, job, in, rowErrors); err != nil {
+		return fmt.Errorf("%w: %w", errDatabase, err)
 	}
 
 	if err := wp.db.SaveProcessedFile(ctx, processedFile); err != nil {
-		log.Printf("Error saving processed file record: %v", err)
+		return fmt.Errorf("%w: %w", errDatabase, err)
+	}
+	return nil
+}
+
+func (wp *WorkerPool) rejectInput(ctx context.Context, processedFile *models.ProcessedFile, err error) {
+	processedFile.Status = "error"
+	processedFile.ErrorMsg = err.Error()
+
+	procErr := &models.ProcessingError{
+		ID:        primitive.NewObjectID(),
+		FileName:  processedFile.FileName,
+		ErrorMsg:  err.Error(),
+		CreatedAt: time.Now(),
+	}
+
+	if saveErr := wp.db.SaveProcessingError(ctx, procErr); saveErr != nil {
+		log.Printf("Error saving processing error: %v", saveErr)
+	}
+
+	if saveErr := wp.db.SaveProcessedFile(ctx, processedFile); saveErr != nil {
+		log.Printf("Error saving processed file record: %v", saveErr)
+	}
+}
+
+func (wp *WorkerPool) finishFile(job Job, outcome fileOutcome) {
+	switch outcome {
+	case outcomeSuccess:
+		wp.cleanupFile(job.Source.OutputDir, job.FilePath, job.FileName)
+	case outcomeRejected:
+		wp.moveFileToError(job.Source.OutputDir, job.FilePath, job.FileName)
+	default:
+		return
+	}
+	wp.readiness.release(job.FilePath)
+}
+
+// saveRowErrors replaces the row errors recorded for the file by an earlier
+// attempt with those of this one.
+func (wp *WorkerPool) saveRowErrors(ctx context.Context, job Job, in inputFile, rowErrors []RowError) error {
+	if err := wp.db.DeleteRowErrors(ctx, job.Source.Name, in.Name, in.Hash); err != nil {
+		return err
+	}
+	if len(rowErrors) == 0 {
+		return nil
 	}
 
-	wp.cleanupFile(job.FilePath)
+	procErrs := make([]*models.ProcessingError, len(rowErrors))
+	for i, rowErr := range rowErrors {
+		procErrs[i] = &models.ProcessingError{
+			ID:        primitive.NewObjectID(),
+			FileName:  in.Name,
+			FileHash:  in.Hash,
+			Source:    job.Source.Name,
+			RowNum:    rowErr.Row,
+			Column:    rowErr.Column,
+			ErrorMsg:  rowErr.Reason,
+			CreatedAt: time.Now(),
+		}
+	}
 
-	log.Printf("Successfully processed file: %s (%d records)", job.FileName, len(records))
+	return wp.db.SaveProcessingErrors(ctx, procErrs)
 }
 
-func (wp *WorkerPool) getUniqueUnitGUIDs(records []*models.DeviceData) []string {
-	guidMap := make(map[string]bool)
-	for _, record := range records {
-		guidMap[record.UnitGUID] = true
+// discardFileData removes the rows and row errors stored by an unfinished
+// attempt. It also runs after the job was cancelled or the pool shut down,
+// so it does not stop with ctx.
+func (wp *WorkerPool) discardFileData(ctx context.Context, job Job, in inputFile) {
+	ctx = context.WithoutCancel(ctx)
+	if err := wp.db.DeleteRowErrors(ctx, job.Source.Name, in.Name, in.Hash); err != nil {
+		log.Printf("Error removing row errors for file %s: %v", in.Name, err)
 	}
 
-	guids := make([]string, 0, len(guidMap))
-	for guid := range guidMap {
-		guids = append(guids, guid)
+	deleted, err := wp.db.DeleteDeviceDataByFile(ctx, job.Source.Name, in.Name, in.Hash)
+	if err != nil {
+		log.Printf("Error removing partially stored data for file %s: %v", in.Name, err)
+		return
+	}
+	if deleted > 0 {
+		log.Printf("Removed %d partially stored records for file %s", deleted, in.Name)
 	}
-	return guids
 }
 
-func (wp *WorkerPool) moveFileToError(filePath, fileName string) {
-	errorDir := filepath.Join(wp.cfg.OutputDir, "errors")
-	if err := os.MkdirAll(errorDir, 0755); err != nil {
+// moveFileToError and cleanupFile move a finished file to the errors and
+// archive directories, keeping its path relative to the input directory.
+func (wp *WorkerPool) moveFileToError(outputDir, filePath, fileName string) {
+	destPath := filepath.Join(outputDir, "errors", filepath.FromSlash(fileName))
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
 		log.Printf("Error creating error directory: %v", err)
 		return
 	}
 
-	destPath := filepath.Join(errorDir, fileName)
 	if err := os.Rename(filePath, destPath); err != nil {
 		log.Printf("Error moving file to error directory: %v", err)
 	}
 }
 
-func (wp *WorkerPool) cleanupFile(filePath string) {
-	archiveDir := filepath.Join(wp.cfg.OutputDir, "archive")
-	if err := os.MkdirAll(archiveDir, 0755); err != nil {
+func (wp *WorkerPool) cleanupFile(outputDir, filePath, fileName string) {
+	destPath := filepath.Join(outputDir, "archive", filepath.FromSlash(fileName))
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
 		log.Printf("Error creating archive directory: %v", err)
 		return
 	}
 
-	destPath := filepath.Join(archiveDir, filepath.Base(filePath))
 	if err := os.Rename(filePath, destPath); err != nil {
 		log.Printf("Error moving file to archive: %v", err)
 	}